@@ -0,0 +1,40 @@
+/*
+Package awssession centralizes session construction for the AWS-backed
+backends. Shared config and SSO profiles (AWS_PROFILE) are honored, STS
+calls use the regional endpoint, and an optional assume-role with external
+ID can be layered on top of the base credentials so role chaining works
+without wrapper scripts.
+*/
+package awssession
+
+import (
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials/stscreds"
+	"github.com/aws/aws-sdk-go/aws/endpoints"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// New builds a session from base, optionally assuming roleARN with
+// externalID. A nil base uses the SDK defaults.
+func New(base *aws.Config, roleARN, externalID string) (*session.Session, error) {
+	if base == nil {
+		base = &aws.Config{}
+	}
+	base.STSRegionalEndpoint = endpoints.RegionalSTSEndpoint
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+		Config:            *base,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if roleARN != "" {
+		creds := stscreds.NewCredentials(sess, roleARN, func(p *stscreds.AssumeRoleProvider) {
+			if externalID != "" {
+				p.ExternalID = aws.String(externalID)
+			}
+		})
+		sess = sess.Copy(&aws.Config{Credentials: creds})
+	}
+	return sess, nil
+}
@@ -0,0 +1,194 @@
+package backends
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/abtreece/confd/log"
+)
+
+// ErrCircuitOpen is returned for calls made while the circuit breaker is
+// open because the backend recently failed too many times in a row.
+var ErrCircuitOpen = errors.New("backend circuit breaker is open")
+
+// ErrTooManyHungRequests is returned when too many timed-out calls are still
+// blocked inside the wrapped client, instead of starting another one.
+var ErrTooManyHungRequests = errors.New("backend has too many hung requests in flight")
+
+// maxHungRequests caps the worker goroutines left blocked in the wrapped
+// client by timed-out calls. StoreClient calls take no deadline, so a worker
+// abandoned on timeout only exits when the backend finally answers; against
+// a permanently hung backend the cap bounds the accumulation that half-open
+// probes would otherwise grow by one goroutine per open period forever.
+const maxHungRequests = 8
+
+// circuitBreakerClient wraps a StoreClient with a per-call timeout and a
+// circuit breaker. After threshold consecutive failures the circuit opens
+// and calls fail fast for openDuration; a single half-open probe is then let
+// through and the circuit closes again on success.
+type circuitBreakerClient struct {
+	client       StoreClient
+	timeout      time.Duration
+	threshold    int
+	openDuration time.Duration
+
+	mu       sync.Mutex
+	failures int
+	openedAt time.Time
+	probing  bool
+	// hung counts workers abandoned by timed-out calls that are still
+	// blocked in the wrapped client.
+	hung int
+}
+
+// wrapCircuitBreaker wraps client according to config. The client is
+// returned unchanged when neither a request timeout nor a failure threshold
+// is configured.
+func wrapCircuitBreaker(client StoreClient, config Config) StoreClient {
+	if config.RequestTimeout <= 0 && config.CircuitThreshold <= 0 {
+		return client
+	}
+	openDuration := time.Duration(config.CircuitOpenDuration) * time.Second
+	if openDuration <= 0 {
+		openDuration = 10 * time.Second
+	}
+	return &circuitBreakerClient{
+		client:       client,
+		timeout:      time.Duration(config.RequestTimeout) * time.Second,
+		threshold:    config.CircuitThreshold,
+		openDuration: openDuration,
+	}
+}
+
+// allow reports whether a call may proceed given the circuit state.
+func (c *circuitBreakerClient) allow() error {
+	if c.threshold <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures < c.threshold {
+		return nil
+	}
+	if time.Since(c.openedAt) < c.openDuration || c.probing {
+		return ErrCircuitOpen
+	}
+	// Let a single half-open probe through.
+	c.probing = true
+	return nil
+}
+
+// record updates the circuit state with the outcome of a call.
+func (c *circuitBreakerClient) record(err error) {
+	if c.threshold <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+	if err == nil {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	if c.failures == c.threshold {
+		log.Warning(fmt.Sprintf("Backend failed %d times in a row. Opening circuit for %s", c.failures, c.openDuration))
+	}
+	if c.failures >= c.threshold {
+		c.openedAt = time.Now()
+	}
+}
+
+func (c *circuitBreakerClient) GetValues(keys []string) (map[string]string, error) {
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	if c.hung >= maxHungRequests {
+		c.mu.Unlock()
+		c.record(ErrTooManyHungRequests)
+		return nil, ErrTooManyHungRequests
+	}
+	c.mu.Unlock()
+	type result struct {
+		vars map[string]string
+		err  error
+	}
+	resultChan := make(chan result, 1)
+	abandoned := false
+	go func() {
+		vars, err := c.client.GetValues(keys)
+		resultChan <- result{vars, err}
+		c.mu.Lock()
+		if abandoned {
+			c.hung--
+		}
+		c.mu.Unlock()
+	}()
+
+	var timeoutChan <-chan time.Time
+	if c.timeout > 0 {
+		timeoutChan = time.After(c.timeout)
+	}
+	select {
+	case r := <-resultChan:
+		c.record(r.err)
+		return r.vars, r.err
+	case <-timeoutChan:
+		// The worker stays blocked in the wrapped client; count it so the
+		// cap above stops new calls once too many pile up. A late result
+		// that raced the timeout is still returned instead.
+		c.mu.Lock()
+		select {
+		case r := <-resultChan:
+			c.mu.Unlock()
+			c.record(r.err)
+			return r.vars, r.err
+		default:
+		}
+		abandoned = true
+		c.hung++
+		c.mu.Unlock()
+		err := fmt.Errorf("backend request timed out after %s", c.timeout)
+		c.record(err)
+		return nil, err
+	}
+}
+
+// WatchPrefix blocks until the backend reports a change, so no per-call
+// timeout is applied; only the circuit breaker state is maintained.
+func (c *circuitBreakerClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if err := c.allow(); err != nil {
+		return 0, err
+	}
+	index, err := c.client.WatchPrefix(prefix, keys, waitIndex, stopChan)
+	c.record(err)
+	return index, err
+}
+
+// Revision forwards to the wrapped client.
+func (c *circuitBreakerClient) Revision() uint64 {
+	return revisionOf(c.client)
+}
+
+// KeyMeta reports per-key metadata from the wrapped client.
+func (c *circuitBreakerClient) KeyMeta(key string) (uint64, string, uint64, bool) {
+	return keyMetaOf(c.client, key)
+}
+
+// IssueCert forwards to the wrapped client.
+func (c *circuitBreakerClient) IssueCert(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	return issueCertOf(c.client, path, data)
+}
+
+// AcquireSemaphore forwards to the wrapped client.
+func (c *circuitBreakerClient) AcquireSemaphore(prefix string, limit int) (func() error, error) {
+	return acquireSemaphoreOf(c.client, prefix, limit)
+}
+
+// KeyExpiry forwards to the wrapped client.
+func (c *circuitBreakerClient) KeyExpiry(key string) (time.Time, bool) {
+	return keyExpiryOf(c.client, key)
+}
@@ -2,13 +2,16 @@ package backends
 
 import (
 	"errors"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/abtreece/confd/backends/consul"
 	"github.com/abtreece/confd/backends/dynamodb"
 	"github.com/abtreece/confd/backends/env"
 	"github.com/abtreece/confd/backends/etcd"
 	"github.com/abtreece/confd/backends/file"
+	"github.com/abtreece/confd/backends/plugin"
 	"github.com/abtreece/confd/backends/rancher"
 	"github.com/abtreece/confd/backends/redis"
 	"github.com/abtreece/confd/backends/ssm"
@@ -24,62 +27,231 @@ type StoreClient interface {
 	WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error)
 }
 
+// Revisioner is implemented by store clients that can report the backend
+// revision or query index their last GetValues call read at, so templates
+// can embed it in generated files for auditing.
+type Revisioner interface {
+	Revision() uint64
+}
+
+// revisionOf unwraps client to the underlying Revisioner, if any.
+func revisionOf(client StoreClient) uint64 {
+	if r, ok := client.(Revisioner); ok {
+		return r.Revision()
+	}
+	return 0
+}
+
+// Revision reports the revision of the wrapped client's last read.
+func Revision(client StoreClient) uint64 {
+	return revisionOf(client)
+}
+
+// MetaReader is implemented by store clients that retain per-key metadata
+// (flags, lock session, modify index) alongside values.
+type MetaReader interface {
+	KeyMeta(key string) (flags uint64, session string, modifyIndex uint64, ok bool)
+}
+
+// keyMetaOf unwraps client to the underlying MetaReader, if any.
+func keyMetaOf(client StoreClient, key string) (uint64, string, uint64, bool) {
+	if m, ok := client.(MetaReader); ok {
+		return m.KeyMeta(key)
+	}
+	return 0, "", 0, false
+}
+
+// KeyMeta reports the metadata the wrapped client recorded for key.
+func KeyMeta(client StoreClient, key string) (uint64, string, uint64, bool) {
+	return keyMetaOf(client, key)
+}
+
+// CertIssuer is implemented by store clients that can issue certificates on
+// demand, such as the Vault PKI secrets engine.
+type CertIssuer interface {
+	IssueCert(path string, data map[string]interface{}) (map[string]interface{}, error)
+}
+
+// issueCertOf unwraps client to the underlying CertIssuer, if any.
+func issueCertOf(client StoreClient, path string, data map[string]interface{}) (map[string]interface{}, error) {
+	if i, ok := client.(CertIssuer); ok {
+		return i.IssueCert(path, data)
+	}
+	return nil, errors.New("the configured backend cannot issue certificates")
+}
+
+// IssueCert issues a certificate through the wrapped client. It returns an
+// error for backends without a PKI capability.
+func IssueCert(client StoreClient, path string, data map[string]interface{}) (map[string]interface{}, error) {
+	return issueCertOf(client, path, data)
+}
+
+// ReloadBarrier is implemented by store clients that can coordinate
+// fleet-wide reloads through a backend semaphore.
+type ReloadBarrier interface {
+	AcquireSemaphore(prefix string, limit int) (func() error, error)
+}
+
+// acquireSemaphoreOf unwraps client to the underlying ReloadBarrier, if any.
+func acquireSemaphoreOf(client StoreClient, prefix string, limit int) (func() error, error) {
+	if b, ok := client.(ReloadBarrier); ok {
+		return b.AcquireSemaphore(prefix, limit)
+	}
+	return nil, errors.New("the configured backend cannot coordinate reloads")
+}
+
+// AcquireSemaphore blocks until one of limit semaphore slots under prefix
+// is held and returns a function releasing the slot. It returns an error
+// for backends without a coordination capability.
+func AcquireSemaphore(client StoreClient, prefix string, limit int) (func() error, error) {
+	return acquireSemaphoreOf(client, prefix, limit)
+}
+
+// TTLReader is implemented by store clients that can report when the value
+// last read for a key expires (etcd lease, expiration policy).
+type TTLReader interface {
+	KeyExpiry(key string) (time.Time, bool)
+}
+
+// keyExpiryOf unwraps client to the underlying TTLReader, if any.
+func keyExpiryOf(client StoreClient, key string) (time.Time, bool) {
+	if r, ok := client.(TTLReader); ok {
+		return r.KeyExpiry(key)
+	}
+	return time.Time{}, false
+}
+
+// KeyExpiry reports when the value the wrapped client last read for key
+// expires, if the backend attaches TTL or lease metadata.
+func KeyExpiry(client StoreClient, key string) (time.Time, bool) {
+	return keyExpiryOf(client, key)
+}
+
+// WithOverrides layers per-resource request timeout and retry settings on
+// top of an existing store client. A zero config returns the client
+// unchanged.
+func WithOverrides(client StoreClient, config Config) StoreClient {
+	return wrapCircuitBreaker(wrapRetry(client, config), config)
+}
+
+// WithPrefixRewrite layers a single prefix mapping on top of an existing
+// store client: strip is removed from the front of matching keys before
+// queries and add is prepended, with fetched keys mapped back. It lets a
+// shared template be pointed at differently structured data in different
+// backends without editing the template or its keys list.
+func WithPrefixRewrite(client StoreClient, strip string, add string) StoreClient {
+	if strip == "" && add == "" {
+		return client
+	}
+	return &rewriteClient{client: client, rules: []rewriteRule{{
+		prefix:  strings.TrimSuffix(strip, "/"),
+		replace: strings.TrimSuffix(add, "/"),
+	}}}
+}
+
 // New is used to create a storage client based on our configuration.
 func New(config Config) (StoreClient, error) {
 
 	if config.Backend == "" {
 		config.Backend = "etcd"
 	}
-	backendNodes := config.BackendNodes
 
 	if config.Backend == "file" {
 		log.Info("Backend source(s) set to " + strings.Join(config.YAMLFile, ", "))
 	} else {
-		log.Info("Backend source(s) set to " + strings.Join(backendNodes, ", "))
+		log.Info("Backend source(s) set to " + strings.Join(config.BackendNodes, ", "))
+	}
+
+	var (
+		client StoreClient
+		err    error
+	)
+	if config.AuthTokenFile != "" || config.PasswordFile != "" {
+		client, err = newCredentialReloadClient(config)
+	} else {
+		client, err = newStoreClient(config)
+	}
+	if err != nil {
+		return nil, err
 	}
+	client = wrapInstrumentation(client, config.Backend)
+	// Key rewriting sits outermost so every inner layer sees backend paths.
+	return wrapRewrite(wrapCircuitBreaker(wrapRetry(client, config), config), config)
+}
 
+// newStoreClient creates the backend specific storage client.
+func newStoreClient(config Config) (StoreClient, error) {
+	backendNodes := config.BackendNodes
+	var (
+		client StoreClient
+		err    error
+	)
 	switch config.Backend {
 	case "consul":
-		return consul.New(config.BackendNodes, config.Scheme,
+		client, err = consul.New(config.BackendNodes, config.Scheme,
 			config.ClientCert, config.ClientKey,
 			config.ClientCaKeys,
 			config.BasicAuth,
 			config.Username,
 			config.Password,
+			config.Datacenter,
+			config.Namespace,
+			config.Partition,
+			config.ProxyURL,
+			config.NoRecurse,
 		)
 	case "etcd":
-		return etcd.NewEtcdClient(backendNodes, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.BasicAuth, config.Username, config.Password)
+		client, err = etcd.NewEtcdClient(backendNodes, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.ClientServerName, config.ClientSkipHostnameVerify, config.BasicAuth, config.Username, config.Password, config.EtcdDialTimeout, config.EtcdKeepAliveTime, config.EtcdKeepAliveTimeout, config.EtcdPermitWithoutStream)
 	case "zookeeper":
-		return zookeeper.NewZookeeperClient(backendNodes)
+		client, err = zookeeper.NewZookeeperClient(backendNodes, config.Username, config.Password, config.ClientCert, config.ClientKey, config.ClientCaKeys)
 	case "rancher":
-		return rancher.NewRancherClient(backendNodes)
+		client, err = rancher.NewRancherClient(backendNodes)
 	case "redis":
-		return redis.NewRedisClient(backendNodes, config.ClientKey, config.Separator)
+		// The redis password historically rides in -client-key; an explicit
+		// -password wins so TLS client keys and ACL passwords can coexist.
+		redisPassword := config.Password
+		if redisPassword == "" {
+			redisPassword = config.ClientKey
+		}
+		client, err = redis.NewRedisClient(backendNodes, config.Username, redisPassword, config.Separator, config.ClientCert, config.ClientKey, config.ClientCaKeys, config.RedisClientTracking)
 	case "env":
-		return env.NewEnvClient()
+		client, err = env.NewEnvClient(config.EnvCasing, config.EnvSeparator, config.EnvStripPrefix)
+	case "plugin":
+		client, err = plugin.NewPluginClient(config.PluginCmd)
+	case "composite":
+		client, err = newCompositeClient(config)
 	case "file":
-		return file.NewFileClient(config.YAMLFile, config.Filter)
+		client, err = file.NewFileClient(config.YAMLFile, config.Filter, config.MergePolicy, config.WatchStrategy, config.FileFormat, config.FileDocKey)
 	case "vault":
 		vaultConfig := map[string]string{
-			"app-id":    config.AppID,
-			"user-id":   config.UserID,
-			"role-id":   config.RoleID,
-			"secret-id": config.SecretID,
-			"username":  config.Username,
-			"password":  config.Password,
-			"token":     config.AuthToken,
-			"cert":      config.ClientCert,
-			"key":       config.ClientKey,
-			"caCert":    config.ClientCaKeys,
-			"path":      config.Path,
+			"app-id":            config.AppID,
+			"user-id":           config.UserID,
+			"role-id":           config.RoleID,
+			"secret-id":         config.SecretID,
+			"username":          config.Username,
+			"password":          config.Password,
+			"token":             config.AuthToken,
+			"cert":              config.ClientCert,
+			"key":               config.ClientKey,
+			"caCert":            config.ClientCaKeys,
+			"path":              config.Path,
+			"watch-interval":    strconv.Itoa(config.VaultWatchInterval),
+			"proxy":             config.ProxyURL,
+			"wrapped-secret-id": config.WrappedSecretID,
+			"unwrap":            strconv.FormatBool(config.VaultUnwrap),
 		}
-		return vault.New(backendNodes[0], config.AuthType, vaultConfig)
+		client, err = vault.New(backendNodes[0], config.AuthType, vaultConfig)
 	case "dynamodb":
 		table := config.Table
 		log.Info("DynamoDB table set to " + table)
-		return dynamodb.NewDynamoDBClient(table)
+		client, err = dynamodb.NewDynamoDBClient(table, config.ProxyURL, config.RoleARN, config.RoleExternalID)
 	case "ssm":
-		return ssm.New()
+		client, err = ssm.New(config.ProxyURL, config.RoleARN, config.RoleExternalID, config.SSMWatchQueue)
+	default:
+		return nil, errors.New("Invalid backend")
+	}
+	if err != nil {
+		return nil, err
 	}
-	return nil, errors.New("Invalid backend")
+	return client, nil
 }
@@ -0,0 +1,109 @@
+package backends
+
+import (
+	"errors"
+	"path"
+	"strings"
+)
+
+// compositeMount is one backend of a composite client together with the key
+// prefix it is mounted under.
+type compositeMount struct {
+	prefix string
+	client StoreClient
+}
+
+// compositeClient merges several backends into a single key namespace. Each
+// mount serves the subtree under its prefix, so a template can read static
+// file data, secrets, and discovery data through one store client instead of
+// three confd processes writing partial files.
+type compositeClient struct {
+	mounts []compositeMount
+}
+
+// newCompositeClient builds one store client per configured mount. Mount
+// configurations are self-contained: defaults such as backend nodes are not
+// inherited from the top-level configuration.
+func newCompositeClient(config Config) (*compositeClient, error) {
+	if len(config.Mounts) == 0 {
+		return nil, errors.New("no mounts configured for the composite backend")
+	}
+	mounts := make([]compositeMount, 0, len(config.Mounts))
+	for _, m := range config.Mounts {
+		if m.Backend == "" || m.Backend == "composite" {
+			return nil, errors.New("every composite mount needs a backend other than composite")
+		}
+		client, err := newStoreClient(m.Config)
+		if err != nil {
+			return nil, err
+		}
+		mounts = append(mounts, compositeMount{
+			prefix: path.Join("/", m.Prefix),
+			client: client,
+		})
+	}
+	return &compositeClient{mounts: mounts}, nil
+}
+
+// GetValues fans the requested keys out to the mounts they fall under and
+// merges the results, re-prefixed with each mount's prefix.
+func (c *compositeClient) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	for _, m := range c.mounts {
+		var mountKeys []string
+		for _, k := range keys {
+			switch {
+			case k == m.prefix || strings.HasPrefix(k, m.prefix+"/"):
+				mountKeys = append(mountKeys, path.Join("/", strings.TrimPrefix(k, m.prefix)))
+			case m.prefix == "/" || strings.HasPrefix(m.prefix, strings.TrimSuffix(k, "/")+"/") || k == "/":
+				// The requested key is an ancestor of the mount: the whole
+				// mount is in scope.
+				mountKeys = append(mountKeys, "/")
+			}
+		}
+		if len(mountKeys) == 0 {
+			continue
+		}
+		fetched, err := m.client.GetValues(mountKeys)
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range fetched {
+			vars[path.Join(m.prefix, k)] = v
+		}
+	}
+	return vars, nil
+}
+
+// WatchPrefix watches every mount concurrently and returns as soon as one of
+// them reports a change.
+func (c *compositeClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	type watchResult struct {
+		index uint64
+		err   error
+	}
+	resultChan := make(chan watchResult, len(c.mounts))
+	innerStop := make(chan bool)
+	for _, m := range c.mounts {
+		go func(m compositeMount) {
+			index, err := m.client.WatchPrefix("/", []string{"/"}, waitIndex, innerStop)
+			resultChan <- watchResult{index, err}
+		}(m)
+	}
+	select {
+	case <-stopChan:
+		close(innerStop)
+		return waitIndex, nil
+	case r := <-resultChan:
+		close(innerStop)
+		if r.err != nil {
+			return waitIndex, r.err
+		}
+		// Mounts keep independent indexes, so the composite index is only a
+		// change counter.
+		return waitIndex + 1, nil
+	}
+}
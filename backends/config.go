@@ -4,27 +4,128 @@ import (
 	util "github.com/abtreece/confd/util"
 )
 
+// Mount declares one backend of the composite backend and the prefix its
+// keys are exposed under.
+type Mount struct {
+	Prefix string `toml:"prefix"`
+	Config
+}
+
 type Config struct {
-	AuthToken      string     `toml:"auth_token"`
-	AuthType       string     `toml:"auth_type"`
-	Backend        string     `toml:"backend"`
-	BasicAuth      bool       `toml:"basic_auth"`
-	ClientCaKeys   string     `toml:"client_cakeys"`
-	ClientCert     string     `toml:"client_cert"`
-	ClientKey      string     `toml:"client_key"`
-	ClientInsecure bool       `toml:"client_insecure"`
-	BackendNodes   util.Nodes `toml:"nodes"`
-	Password       string     `toml:"password"`
-	Scheme         string     `toml:"scheme"`
-	Table          string     `toml:"table"`
-	Separator      string     `toml:"separator"`
-	Username       string     `toml:"username"`
-	AppID          string     `toml:"app_id"`
-	UserID         string     `toml:"user_id"`
-	RoleID         string     `toml:"role_id"`
-	SecretID       string     `toml:"secret_id"`
-	YAMLFile       util.Nodes `toml:"file"`
-	Filter         string     `toml:"filter"`
-	Path           string     `toml:"path"`
-	Role           string
+	AuthToken      string `toml:"auth_token"`
+	AuthTokenFile  string `toml:"auth_token_file"`
+	AuthType       string `toml:"auth_type"`
+	Backend        string `toml:"backend"`
+	BasicAuth      bool   `toml:"basic_auth"`
+	ClientCaKeys   string `toml:"client_cakeys"`
+	ClientCert     string `toml:"client_cert"`
+	ClientKey      string `toml:"client_key"`
+	ClientInsecure bool   `toml:"client_insecure"`
+	// ClientServerName overrides the server name used for TLS SNI and
+	// certificate verification (only used with -backend=etcd).
+	ClientServerName string `toml:"client_server_name"`
+	// ClientSkipHostnameVerify skips the TLS hostname match while still
+	// verifying the certificate chain against the CA, for connections
+	// through load balancers whose VIP name is not in the certificate
+	// (only used with -backend=etcd).
+	ClientSkipHostnameVerify bool       `toml:"client_skip_hostname_verify"`
+	Datacenter               string     `toml:"datacenter"`
+	Namespace                string     `toml:"namespace"`
+	Partition                string     `toml:"partition"`
+	BackendNodes             util.Nodes `toml:"nodes"`
+	Password                 string     `toml:"password"`
+	PasswordFile             string     `toml:"password_file"`
+	Scheme                   string     `toml:"scheme"`
+	Table                    string     `toml:"table"`
+	Separator                string     `toml:"separator"`
+	Username                 string     `toml:"username"`
+	AppID                    string     `toml:"app_id"`
+	UserID                   string     `toml:"user_id"`
+	RoleID                   string     `toml:"role_id"`
+	SecretID                 string     `toml:"secret_id"`
+	YAMLFile                 util.Nodes `toml:"file"`
+	Filter                   string     `toml:"filter"`
+	MergePolicy              string     `toml:"merge_policy"`
+	WatchStrategy            string     `toml:"watch_strategy"`
+	Path                     string     `toml:"path"`
+	Role                     string
+
+	// RequestTimeout bounds each backend call in seconds. Zero disables the
+	// timeout.
+	RequestTimeout int `toml:"request_timeout"`
+	// CircuitThreshold is the number of consecutive backend failures after
+	// which the circuit breaker opens. Zero disables the circuit breaker.
+	CircuitThreshold int `toml:"circuit_threshold"`
+	// CircuitOpenDuration is how long in seconds the circuit stays open
+	// before a half-open probe is attempted.
+	CircuitOpenDuration int `toml:"circuit_open_duration"`
+
+	// EtcdDialTimeout, EtcdKeepAliveTime, and EtcdKeepAliveTimeout tune the
+	// etcd gRPC connection in seconds. Zero values keep the client defaults.
+	EtcdDialTimeout      int `toml:"etcd_dial_timeout"`
+	EtcdKeepAliveTime    int `toml:"etcd_keepalive_time"`
+	EtcdKeepAliveTimeout int `toml:"etcd_keepalive_timeout"`
+	// EtcdPermitWithoutStream allows keepalive pings without active RPCs.
+	EtcdPermitWithoutStream bool `toml:"etcd_permit_without_stream"`
+
+	// MaxRetries is the number of times a failed backend read is retried.
+	// Zero disables retries.
+	MaxRetries int `toml:"max_retries"`
+	// RetryBaseDelay is the initial backoff delay in milliseconds.
+	RetryBaseDelay int `toml:"retry_base_delay"`
+	// RetryMaxDelay caps the backoff delay in milliseconds.
+	RetryMaxDelay int `toml:"retry_max_delay"`
+	// RetryJitterFactor randomizes each delay by up to the given fraction.
+	RetryJitterFactor float64 `toml:"retry_jitter_factor"`
+	// PluginCmd is the command used to launch an external backend plugin.
+	PluginCmd string `toml:"plugin_cmd"`
+	// ProxyURL routes backend connections through an explicit proxy.
+	ProxyURL string `toml:"proxy_url"`
+	// RoleARN is assumed on top of the base AWS credentials.
+	RoleARN string `toml:"role_arn"`
+	// RoleExternalID is passed when assuming RoleARN.
+	RoleExternalID string `toml:"role_external_id"`
+	// NoRecurse fetches only the exact keys listed instead of recursing
+	// each one as a prefix.
+	NoRecurse bool `toml:"no_recurse"`
+	// FileFormat forces a parser for the file backend instead of detecting
+	// one per file by extension.
+	FileFormat string `toml:"file_format"`
+	// FileDocKey is the path of a field naming each document in multi-doc
+	// YAML and JSON Lines files, e.g. metadata/name. Documents are indexed
+	// by position when unset.
+	FileDocKey string `toml:"file_doc_key"`
+	// WrappedSecretID is a Vault response-wrapping token holding the
+	// AppRole secret-id.
+	WrappedSecretID string `toml:"wrapped_secret_id"`
+	// VaultUnwrap treats the auth token as a response-wrapping token and
+	// unwraps it before use.
+	VaultUnwrap bool `toml:"vault_unwrap"`
+	// Mounts declares the backends merged by the composite backend, each
+	// under its own key prefix.
+	Mounts []Mount `toml:"mounts"`
+	// RedisClientTracking watches via server invalidation messages instead
+	// of keyspace notifications.
+	RedisClientTracking bool `toml:"redis_client_tracking"`
+	// VaultWatchInterval is the seconds between KV metadata polls when
+	// watching the vault backend.
+	VaultWatchInterval int `toml:"vault_watch_interval"`
+	// EnvCasing controls how key paths map to environment variable names:
+	// upper (the default), lower, or preserve.
+	EnvCasing string `toml:"env_casing"`
+	// EnvSeparator stands in for "/" in environment variable names, "_" by
+	// default.
+	EnvSeparator string `toml:"env_separator"`
+	// EnvStripPrefix is removed from environment variable names before they
+	// are mapped back to key paths.
+	EnvStripPrefix string `toml:"env_strip_prefix"`
+	// SSMWatchQueue is the URL of an SQS queue receiving EventBridge
+	// Parameter Store change events, enabling watch mode for the ssm
+	// backend.
+	SSMWatchQueue string `toml:"ssm_watch_queue"`
+	// KeyRewrites maps template-side key namespaces to backend paths. Plain
+	// entries rewrite a path prefix; entries starting with "~" are regular
+	// expressions with $1-style references in the replacement. Fetched keys
+	// are mapped back, so templates keep seeing the layout they request.
+	KeyRewrites map[string]string `toml:"key_rewrites"`
 }
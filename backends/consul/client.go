@@ -1,8 +1,13 @@
 package consul
 
 import (
+	"errors"
+	"net/http"
+	"net/url"
 	"path"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hashicorp/consul/api"
 )
@@ -10,13 +15,33 @@ import (
 // Client provides a wrapper around the consulkv client
 type ConsulClient struct {
 	client *api.KV
+	// Full api client, kept for session-based coordination like the
+	// reload semaphore.
+	apiClient *api.Client
+	// Highest query index observed by the last GetValues call.
+	lastIndex uint64
+	// With noRecurse set, GetValues fetches only the exact keys listed
+	// instead of listing each one as a prefix.
+	noRecurse bool
+	// Per-key metadata recorded by the last GetValues call, keyed by
+	// absolute key path.
+	mu   sync.Mutex
+	meta map[string]*api.KVPair
 }
 
-// NewConsulClient returns a new client to Consul for the given address
-func New(nodes []string, scheme, cert, key, caCert string, basicAuth bool, username string, password string) (*ConsulClient, error) {
+// NewConsulClient returns a new client to Consul for the given address.
+// The datacenter and namespace query options apply to every request made by
+// the client. Admin partitions are not supported by the bundled consul api
+// client; a configured partition is rejected rather than silently ignored.
+func New(nodes []string, scheme, cert, key, caCert string, basicAuth bool, username string, password string, datacenter, namespace, partition, proxyURL string, noRecurse bool) (*ConsulClient, error) {
+	if partition != "" {
+		return nil, errors.New("consul admin partitions are not supported by the bundled consul api client")
+	}
 	conf := api.DefaultConfig()
 
 	conf.Scheme = scheme
+	conf.Datacenter = datacenter
+	conf.Namespace = namespace
 
 	if len(nodes) > 0 {
 		conf.Address = nodes[0]
@@ -37,29 +62,84 @@ func New(nodes []string, scheme, cert, key, caCert string, basicAuth bool, usern
 		conf.TLSConfig.CAFile = caCert
 	}
 
+	// The default transport already honors HTTP_PROXY/HTTPS_PROXY; an
+	// explicit proxy URL overrides the environment.
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		conf.Transport.Proxy = http.ProxyURL(proxy)
+	}
+
 	client, err := api.NewClient(conf)
 	if err != nil {
 		return nil, err
 	}
-	return &ConsulClient{client.KV()}, nil
+	return &ConsulClient{
+		client:    client.KV(),
+		apiClient: client,
+		noRecurse: noRecurse,
+		meta:      make(map[string]*api.KVPair),
+	}, nil
 }
 
-// GetValues queries Consul for keys
+// GetValues queries Consul for keys. The highest query index across the
+// reads is recorded as the snapshot revision for this call, and each pair's
+// metadata is retained for KeyMeta.
 func (c *ConsulClient) GetValues(keys []string) (map[string]string, error) {
 	vars := make(map[string]string)
+	var index uint64
 	for _, key := range keys {
 		key := strings.TrimPrefix(key, "/")
-		pairs, _, err := c.client.List(key, nil)
+		var (
+			pairs []*api.KVPair
+			meta  *api.QueryMeta
+			err   error
+		)
+		if c.noRecurse {
+			var pair *api.KVPair
+			pair, meta, err = c.client.Get(key, nil)
+			if pair != nil {
+				pairs = []*api.KVPair{pair}
+			}
+		} else {
+			pairs, meta, err = c.client.List(key, nil)
+		}
 		if err != nil {
 			return vars, err
 		}
+		if meta != nil && meta.LastIndex > index {
+			index = meta.LastIndex
+		}
+		c.mu.Lock()
 		for _, p := range pairs {
 			vars[path.Join("/", p.Key)] = string(p.Value)
+			c.meta[path.Join("/", p.Key)] = p
 		}
+		c.mu.Unlock()
 	}
+	atomic.StoreUint64(&c.lastIndex, index)
 	return vars, nil
 }
 
+// KeyMeta returns the Flags, Session, and ModifyIndex recorded for key by
+// the last GetValues call that returned it.
+func (c *ConsulClient) KeyMeta(key string) (uint64, string, uint64, bool) {
+	c.mu.Lock()
+	p, ok := c.meta[path.Join("/", key)]
+	c.mu.Unlock()
+	if !ok {
+		return 0, "", 0, false
+	}
+	return p.Flags, p.Session, p.ModifyIndex, true
+}
+
+// Revision returns the highest query index the last GetValues observed.
+func (c *ConsulClient) Revision() uint64 {
+	return atomic.LoadUint64(&c.lastIndex)
+}
+
 type watchResponse struct {
 	waitIndex uint64
 	err       error
@@ -86,3 +166,17 @@ func (c *ConsulClient) WatchPrefix(prefix string, keys []string, waitIndex uint6
 		return r.waitIndex, r.err
 	}
 }
+
+// AcquireSemaphore claims one of limit slots of the semaphore held under
+// prefix, blocking until a slot is free. The returned release function
+// gives the slot back.
+func (c *ConsulClient) AcquireSemaphore(prefix string, limit int) (func() error, error) {
+	sem, err := c.apiClient.SemaphorePrefix(strings.TrimPrefix(prefix, "/"), limit)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := sem.Acquire(nil); err != nil {
+		return nil, err
+	}
+	return sem.Release, nil
+}
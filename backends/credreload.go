@@ -0,0 +1,166 @@
+package backends
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/abtreece/confd/log"
+)
+
+// credentialReloadClient reads the auth token and password from files and
+// watches them for changes. When a credential file is rewritten (for
+// example, by a Kubernetes projected token volume) the backend client is
+// rebuilt so it re-authenticates with the new credentials, without a
+// restart.
+type credentialReloadClient struct {
+	mu     sync.Mutex
+	config Config
+	client StoreClient
+
+	tokenModTime    time.Time
+	passwordModTime time.Time
+}
+
+// newCredentialReloadClient loads the configured credential files and
+// creates the initial backend client.
+func newCredentialReloadClient(config Config) (*credentialReloadClient, error) {
+	c := &credentialReloadClient{config: config}
+	if err := c.loadCredentials(); err != nil {
+		return nil, err
+	}
+	client, err := newStoreClient(c.config)
+	if err != nil {
+		return nil, err
+	}
+	c.client = client
+	return c, nil
+}
+
+// readCredentialFile returns the whitespace trimmed contents of path and the
+// file modification time.
+func readCredentialFile(path string) (string, time.Time, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", time.Time{}, err
+	}
+	return strings.TrimSpace(string(data)), fi.ModTime(), nil
+}
+
+// loadCredentials reads the credential files into the client configuration.
+func (c *credentialReloadClient) loadCredentials() error {
+	if c.config.AuthTokenFile != "" {
+		token, modTime, err := readCredentialFile(c.config.AuthTokenFile)
+		if err != nil {
+			return err
+		}
+		c.config.AuthToken = token
+		c.tokenModTime = modTime
+	}
+	if c.config.PasswordFile != "" {
+		password, modTime, err := readCredentialFile(c.config.PasswordFile)
+		if err != nil {
+			return err
+		}
+		c.config.Password = password
+		c.passwordModTime = modTime
+	}
+	return nil
+}
+
+// changed reports whether any credential file has been modified since it was
+// last loaded.
+func (c *credentialReloadClient) changed() bool {
+	if c.config.AuthTokenFile != "" {
+		if fi, err := os.Stat(c.config.AuthTokenFile); err == nil && fi.ModTime() != c.tokenModTime {
+			return true
+		}
+	}
+	if c.config.PasswordFile != "" {
+		if fi, err := os.Stat(c.config.PasswordFile); err == nil && fi.ModTime() != c.passwordModTime {
+			return true
+		}
+	}
+	return false
+}
+
+// current returns the backend client, rebuilding it first if the credential
+// files have changed.
+func (c *credentialReloadClient) current() (StoreClient, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.changed() {
+		log.Info("Backend credentials changed on disk. Re-authenticating")
+		if err := c.loadCredentials(); err != nil {
+			return nil, err
+		}
+		client, err := newStoreClient(c.config)
+		if err != nil {
+			return nil, err
+		}
+		c.client = client
+	}
+	return c.client, nil
+}
+
+func (c *credentialReloadClient) GetValues(keys []string) (map[string]string, error) {
+	client, err := c.current()
+	if err != nil {
+		return nil, err
+	}
+	return client.GetValues(keys)
+}
+
+func (c *credentialReloadClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	client, err := c.current()
+	if err != nil {
+		return 0, err
+	}
+	return client.WatchPrefix(prefix, keys, waitIndex, stopChan)
+}
+
+// Revision forwards to the current underlying client.
+func (c *credentialReloadClient) Revision() uint64 {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	return revisionOf(client)
+}
+
+// KeyMeta forwards to the current underlying client.
+func (c *credentialReloadClient) KeyMeta(key string) (uint64, string, uint64, bool) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	return keyMetaOf(client, key)
+}
+
+// IssueCert forwards to the current underlying client.
+func (c *credentialReloadClient) IssueCert(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	return issueCertOf(client, path, data)
+}
+
+// AcquireSemaphore forwards to the current underlying client.
+func (c *credentialReloadClient) AcquireSemaphore(prefix string, limit int) (func() error, error) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	return acquireSemaphoreOf(client, prefix, limit)
+}
+
+// KeyExpiry forwards to the current underlying client.
+func (c *credentialReloadClient) KeyExpiry(key string) (time.Time, bool) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	return keyExpiryOf(client, key)
+}
@@ -1,11 +1,13 @@
 package dynamodb
 
 import (
+	"net/http"
+	"net/url"
 	"os"
 
+	"github.com/abtreece/confd/backends/awssession"
 	"github.com/abtreece/confd/log"
 	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/dynamodb"
 )
 
@@ -19,7 +21,7 @@ type Client struct {
 // NewDynamoDBClient returns an *dynamodb.Client with a connection to the region
 // configured via the AWS_REGION environment variable.
 // It returns an error if the connection cannot be made or the table does not exist.
-func NewDynamoDBClient(table string) (*Client, error) {
+func NewDynamoDBClient(table string, proxyURL, roleARN, roleExternalID string) (*Client, error) {
 	var c *aws.Config
 	if os.Getenv("DYNAMODB_LOCAL") != "" {
 		log.Debug("DYNAMODB_LOCAL is set")
@@ -30,16 +32,29 @@ func NewDynamoDBClient(table string) (*Client, error) {
 	} else {
 		c = nil
 	}
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			c = &aws.Config{}
+		}
+		c.HTTPClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	}
 
-	session := session.New(c)
+	sess, err := awssession.New(c, roleARN, roleExternalID)
+	if err != nil {
+		return nil, err
+	}
 
 	// Fail early, if no credentials can be found
-	_, err := session.Config.Credentials.Get()
+	_, err = sess.Config.Credentials.Get()
 	if err != nil {
 		return nil, err
 	}
 
-	d := dynamodb.New(session)
+	d := dynamodb.New(sess)
 
 	// Check if the table exists
 	_, err = d.DescribeTable(&dynamodb.DescribeTableInput{TableName: &table})
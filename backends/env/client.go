@@ -8,14 +8,31 @@ import (
 	"github.com/abtreece/confd/log"
 )
 
-var replacer = strings.NewReplacer("/", "_")
-
 // Client provides a shell for the env client
-type Client struct{}
+type Client struct {
+	casing      string
+	separator   string
+	stripPrefix string
+}
 
-// NewEnvClient returns a new client
-func NewEnvClient() (*Client, error) {
-	return &Client{}, nil
+// NewEnvClient returns a new client. casing controls how key paths map to
+// variable names: "upper" (the default), "lower", or "preserve", which keeps
+// the path's case so /app/dbHost and /app/db/host stay distinct. separator
+// is the string standing in for "/" in variable names, "_" by default.
+// stripPrefix is removed from variable names before they are mapped back to
+// key paths, so APP_DB_HOST can serve /db/host.
+func NewEnvClient(casing, separator, stripPrefix string) (*Client, error) {
+	switch casing {
+	case "":
+		casing = "upper"
+	case "upper", "lower", "preserve":
+	default:
+		return nil, fmt.Errorf("invalid env casing policy: %s (must be upper, lower, or preserve)", casing)
+	}
+	if separator == "" {
+		separator = "_"
+	}
+	return &Client{casing: casing, separator: separator, stripPrefix: stripPrefix}, nil
 }
 
 // GetValues queries the environment for keys
@@ -24,14 +41,18 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	envMap := make(map[string]string)
 	for _, e := range allEnvVars {
 		index := strings.Index(e, "=")
-		envMap[e[:index]] = e[index+1:]
+		name := e[:index]
+		if c.stripPrefix != "" {
+			name = strings.TrimPrefix(name, c.stripPrefix)
+		}
+		envMap[name] = e[index+1:]
 	}
 	vars := make(map[string]string)
 	for _, key := range keys {
-		k := transform(key)
+		k := c.transform(key)
 		for envKey, envValue := range envMap {
 			if strings.HasPrefix(envKey, k) {
-				vars[clean(envKey)] = envValue
+				vars[c.clean(envKey)] = envValue
 			}
 		}
 	}
@@ -41,16 +62,27 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	return vars, nil
 }
 
-func transform(key string) string {
+// transform maps a key path to the variable name prefix it matches.
+func (c *Client) transform(key string) string {
 	k := strings.TrimPrefix(key, "/")
-	return strings.ToUpper(replacer.Replace(k))
+	k = strings.Replace(k, "/", c.separator, -1)
+	switch c.casing {
+	case "lower":
+		return strings.ToLower(k)
+	case "preserve":
+		return k
+	default:
+		return strings.ToUpper(k)
+	}
 }
 
-var cleanReplacer = strings.NewReplacer("_", "/")
-
-func clean(key string) string {
-	newKey := "/" + key
-	return cleanReplacer.Replace(strings.ToLower(newKey))
+// clean maps a variable name back to a key path.
+func (c *Client) clean(key string) string {
+	newKey := "/" + strings.Replace(key, c.separator, "/", -1)
+	if c.casing == "upper" {
+		return strings.ToLower(newKey)
+	}
+	return newKey
 }
 
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
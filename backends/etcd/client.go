@@ -3,6 +3,7 @@ package etcd
 import (
 	"crypto/tls"
 	"crypto/x509"
+	"errors"
 	"io/ioutil"
 	"strings"
 	"time"
@@ -10,6 +11,7 @@ import (
 	"golang.org/x/net/context"
 
 	"sync"
+	"sync/atomic"
 
 	"github.com/abtreece/confd/log"
 	"go.etcd.io/etcd/clientv3"
@@ -103,15 +105,37 @@ type Client struct {
 	watches map[string]*Watch
 	// Protect watch
 	wm sync.Mutex
+	// Revision observed by the last GetValues snapshot.
+	lastRevision uint64
+	// Expiry times recorded for leased keys by the last GetValues call.
+	expiryMu sync.Mutex
+	expiry   map[string]time.Time
 }
 
 // NewEtcdClient returns an *etcd.Client with a connection to named machines.
-func NewEtcdClient(machines []string, cert, key, caCert string, basicAuth bool, username string, password string) (*Client, error) {
+// The dial timeout and gRPC keepalive settings are given in seconds; zero
+// values keep the defaults. keepAlivePermitWithoutStream allows keepalive
+// pings while no RPC streams are active, which detects dead connections on
+// otherwise idle watchers. serverName overrides the name used for SNI and
+// certificate verification, and skipHostnameVerify drops the hostname match
+// while still verifying the chain against the CA - both for connections
+// through load balancers whose VIP name is not in the server certificate.
+func NewEtcdClient(machines []string, cert, key, caCert, serverName string, skipHostnameVerify bool, basicAuth bool, username string, password string, dialTimeout, keepAliveTime, keepAliveTimeout int, keepAlivePermitWithoutStream bool) (*Client, error) {
 	cfg := clientv3.Config{
 		Endpoints:            machines,
 		DialTimeout:          5 * time.Second,
 		DialKeepAliveTime:    10 * time.Second,
 		DialKeepAliveTimeout: 3 * time.Second,
+		PermitWithoutStream:  keepAlivePermitWithoutStream,
+	}
+	if dialTimeout > 0 {
+		cfg.DialTimeout = time.Duration(dialTimeout) * time.Second
+	}
+	if keepAliveTime > 0 {
+		cfg.DialKeepAliveTime = time.Duration(keepAliveTime) * time.Second
+	}
+	if keepAliveTimeout > 0 {
+		cfg.DialKeepAliveTimeout = time.Duration(keepAliveTimeout) * time.Second
 	}
 
 	if basicAuth {
@@ -148,6 +172,20 @@ func NewEtcdClient(machines []string, cert, key, caCert string, basicAuth bool,
 		tlsEnabled = true
 	}
 
+	if serverName != "" {
+		tlsConfig.ServerName = serverName
+		tlsEnabled = true
+	}
+
+	if skipHostnameVerify {
+		// The standard library has no "verify the chain but not the
+		// hostname" mode, so its verification is disabled and chain
+		// verification is re-run against the configured roots.
+		tlsConfig.InsecureSkipVerify = true
+		tlsConfig.VerifyPeerCertificate = verifyChainOnly(tlsConfig.RootCAs)
+		tlsEnabled = true
+	}
+
 	if tlsEnabled {
 		cfg.TLS = tlsConfig
 	}
@@ -157,7 +195,36 @@ func NewEtcdClient(machines []string, cert, key, caCert string, basicAuth bool,
 		return &Client{}, err
 	}
 
-	return &Client{client, make(map[string]*Watch), sync.Mutex{}}, nil
+	return &Client{client: client, watches: make(map[string]*Watch)}, nil
+}
+
+// verifyChainOnly returns a VerifyPeerCertificate callback that validates
+// the presented certificate chain against roots without matching the
+// hostname. A nil roots falls back to the system pool.
+func verifyChainOnly(roots *x509.CertPool) func([][]byte, [][]*x509.Certificate) error {
+	return func(rawCerts [][]byte, _ [][]*x509.Certificate) error {
+		if len(rawCerts) == 0 {
+			return errors.New("no server certificate presented")
+		}
+		certs := make([]*x509.Certificate, len(rawCerts))
+		for i, raw := range rawCerts {
+			c, err := x509.ParseCertificate(raw)
+			if err != nil {
+				return err
+			}
+			certs[i] = c
+		}
+		opts := x509.VerifyOptions{
+			Roots:         roots,
+			Intermediates: x509.NewCertPool(),
+			KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}
+		for _, c := range certs[1:] {
+			opts.Intermediates.AddCert(c)
+		}
+		_, err := certs[0].Verify(opts)
+		return err
+	}
 }
 
 // GetValues queries etcd for keys prefixed by prefix.
@@ -165,6 +232,8 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	// Use all operations on the same revision
 	var first_rev int64 = 0
 	vars := make(map[string]string)
+	// Lease IDs of leased keys, resolved to expiry times after the fetch.
+	leases := make(map[string]int64)
 	// Default ETCDv3 TXN limitation. Since it is configurable from v3.3,
 	// maybe an option should be added (also set max-txn=0 can disable Txn?)
 	maxTxnOps := 128
@@ -197,6 +266,9 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 				k := string(ev.Key)
 				if k == originKey || strings.HasPrefix(k, originKeyFixed) {
 					vars[string(ev.Key)] = string(ev.Value)
+					if ev.Lease != 0 {
+						leases[k] = ev.Lease
+					}
 				}
 			}
 		}
@@ -220,9 +292,54 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 			return vars, err
 		}
 	}
+	atomic.StoreUint64(&c.lastRevision, uint64(first_rev))
+	c.recordExpiry(leases)
 	return vars, nil
 }
 
+// recordExpiry resolves the lease of each leased key to an expiry time, so
+// KeyExpiry can report when a value will disappear. Leases are looked up
+// once per unique ID; lookup failures simply leave the key without expiry
+// metadata.
+func (c *Client) recordExpiry(leases map[string]int64) {
+	expiry := make(map[string]time.Time)
+	ttls := make(map[int64]time.Time)
+	now := time.Now()
+	for key, id := range leases {
+		exp, ok := ttls[id]
+		if !ok {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Duration(3)*time.Second)
+			resp, err := c.client.TimeToLive(ctx, clientv3.LeaseID(id))
+			cancel()
+			if err != nil || resp.TTL <= 0 {
+				ttls[id] = time.Time{}
+				continue
+			}
+			exp = now.Add(time.Duration(resp.TTL) * time.Second)
+			ttls[id] = exp
+		}
+		if !exp.IsZero() {
+			expiry[key] = exp
+		}
+	}
+	c.expiryMu.Lock()
+	c.expiry = expiry
+	c.expiryMu.Unlock()
+}
+
+// KeyExpiry reports when the value last read for key expires.
+func (c *Client) KeyExpiry(key string) (time.Time, bool) {
+	c.expiryMu.Lock()
+	defer c.expiryMu.Unlock()
+	exp, ok := c.expiry[key]
+	return exp, ok
+}
+
+// Revision returns the snapshot revision the last GetValues call read at.
+func (c *Client) Revision() uint64 {
+	return atomic.LoadUint64(&c.lastRevision)
+}
+
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
 	var err error
 
@@ -1,12 +1,17 @@
 package file
 
 import (
+	"crypto/md5"
 	"fmt"
 	"io/ioutil"
+	"os"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/BurntSushi/toml"
 	"github.com/abtreece/confd/log"
 	util "github.com/abtreece/confd/util"
 	"github.com/fsnotify/fsnotify"
@@ -17,8 +22,12 @@ var replacer = strings.NewReplacer("/", "_")
 
 // Client provides a shell for the yaml client
 type Client struct {
-	filepath []string
-	filter   string
+	filepath      []string
+	filter        string
+	mergePolicy   string
+	watchStrategy string
+	format        string
+	docKey        string
 }
 
 type ResultError struct {
@@ -26,27 +35,85 @@ type ResultError struct {
 	err      error
 }
 
-func NewFileClient(filepath []string, filter string) (*Client, error) {
-	return &Client{filepath: filepath, filter: filter}, nil
+func NewFileClient(filepath []string, filter string, mergePolicy string, watchStrategy string, format string, docKey string) (*Client, error) {
+	switch mergePolicy {
+	case "", "first", "last", "error":
+	default:
+		return nil, fmt.Errorf("invalid merge policy: %s", mergePolicy)
+	}
+	switch watchStrategy {
+	case "", "inotify", "poll":
+	default:
+		return nil, fmt.Errorf("invalid watch strategy: %s", watchStrategy)
+	}
+	switch format {
+	case "", "yaml", "json", "toml", "ini", "properties", "dotenv", "jsonl":
+	default:
+		return nil, fmt.Errorf("invalid file format: %s", format)
+	}
+	return &Client{filepath: filepath, filter: filter, mergePolicy: mergePolicy, watchStrategy: watchStrategy, format: format, docKey: docKey}, nil
 }
 
-func readFile(path string, vars map[string]string) error {
-	yamlMap := make(map[interface{}]interface{})
-	data, err := ioutil.ReadFile(path)
-	if err != nil {
-		return err
+// mergeVar merges a single key from file into vars according to the
+// configured merge policy. Conflicting values are logged so configuration
+// collisions across files do not go unnoticed.
+func (c *Client) mergeVar(vars map[string]string, key, value, file string) error {
+	existing, ok := vars[key]
+	if !ok || existing == value {
+		vars[key] = value
+		return nil
 	}
+	switch c.mergePolicy {
+	case "first":
+		log.Warning(fmt.Sprintf("Conflicting values for key %s. Keeping first value and ignoring %s", key, file))
+	case "error":
+		return fmt.Errorf("conflicting values for key %s in %s", key, file)
+	default:
+		log.Warning(fmt.Sprintf("Conflicting values for key %s. Using value from %s", key, file))
+		vars[key] = value
+	}
+	return nil
+}
 
-	err = yaml.Unmarshal(data, &yamlMap)
+// readFile parses the file at path into vars. With an explicit format set it
+// applies to every file; otherwise the parser is chosen per file by
+// extension, with YAML/JSON as the fallback. Multi-document YAML streams and
+// JSON Lines files index each document under its own root, named by the
+// docKey field when configured and by position otherwise.
+func readFile(path string, vars map[string]string, format string, docKey string) error {
+	data, err := ioutil.ReadFile(path)
 	if err != nil {
 		return err
 	}
+	if format == "" || format == "yaml" || format == "json" {
+		format = detectFormat(path)
+	}
 
-	err = nodeWalk(yamlMap, "/", vars)
-	if err != nil {
-		return err
+	switch format {
+	case "toml":
+		tomlMap := make(map[string]interface{})
+		if err := toml.Unmarshal(data, &tomlMap); err != nil {
+			return err
+		}
+		return nodeWalk(tomlMap, "/", vars)
+	case "ini":
+		return parseINI(data, vars)
+	case "properties":
+		return parseProperties(data, vars)
+	case "dotenv":
+		return parseDotenv(data, vars)
+	case "jsonl":
+		return parseJSONL(data, vars, docKey)
+	default:
+		if docs := splitYAMLDocs(data); len(docs) > 1 {
+			return parseMultiYAML(docs, vars, docKey)
+		}
+		yamlMap := make(map[interface{}]interface{})
+		if err := yaml.Unmarshal(data, &yamlMap); err != nil {
+			return err
+		}
+		return nodeWalk(yamlMap, "/", vars)
 	}
-	return nil
 }
 
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
@@ -61,10 +128,16 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	}
 
 	for _, path := range filePaths {
-		err := readFile(path, vars)
+		fileVars := make(map[string]string)
+		err := readFile(path, fileVars, c.format, c.docKey)
 		if err != nil {
 			return nil, err
 		}
+		for k, v := range fileVars {
+			if err := c.mergeVar(vars, k, v, path); err != nil {
+				return nil, err
+			}
+		}
 	}
 
 VarsLoop:
@@ -93,10 +166,17 @@ func nodeWalk(node interface{}, key string, vars map[string]string) error {
 			key := path.Join(key, k.(string))
 			nodeWalk(v, key, vars)
 		}
+	case map[string]interface{}:
+		for k, v := range node.(map[string]interface{}) {
+			key := path.Join(key, k)
+			nodeWalk(v, key, vars)
+		}
 	case string:
 		vars[key] = node.(string)
 	case int:
 		vars[key] = strconv.Itoa(node.(int))
+	case int64:
+		vars[key] = strconv.FormatInt(node.(int64), 10)
 	case bool:
 		vars[key] = strconv.FormatBool(node.(bool))
 	case float64:
@@ -128,11 +208,66 @@ func (c *Client) watchChanges(watcher *fsnotify.Watcher, stopChan chan bool) Res
 	return <-outputChannel
 }
 
+// snapshot returns a digest over the matched file paths, sizes, and
+// modification times, used by the polling watch strategy to detect changes.
+func (c *Client) snapshot() (string, error) {
+	h := md5.New()
+	for _, path := range c.filepath {
+		files, err := util.RecursiveFilesLookup(path, c.filter)
+		if err != nil {
+			return "", err
+		}
+		sort.Strings(files)
+		for _, file := range files {
+			fi, err := os.Stat(file)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(h, "%s%d%s", file, fi.Size(), fi.ModTime())
+		}
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// pollChanges watches the configured files by periodically comparing a
+// snapshot of their metadata. It is used on filesystems where inotify is
+// unavailable or unreliable (NFS, some container volumes).
+func (c *Client) pollChanges(stopChan chan bool) ResultError {
+	last, err := c.snapshot()
+	if err != nil {
+		return ResultError{response: 0, err: err}
+	}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return ResultError{response: 1, err: nil}
+		case <-ticker.C:
+			current, err := c.snapshot()
+			if err != nil {
+				return ResultError{response: 0, err: err}
+			}
+			if current != last {
+				return ResultError{response: 1, err: nil}
+			}
+		}
+	}
+}
+
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
 	if waitIndex == 0 {
 		return 1, nil
 	}
 
+	if c.watchStrategy == "poll" {
+		output := c.pollChanges(stopChan)
+		if output.response != 2 {
+			return output.response, output.err
+		}
+		return waitIndex, nil
+	}
+
 	watcher, err := fsnotify.NewWatcher()
 	if err != nil {
 		return 0, err
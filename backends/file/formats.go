@@ -0,0 +1,187 @@
+package file
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// detectFormat maps a file extension to a parser name. Files with unknown
+// extensions fall back to the YAML parser, which also covers JSON.
+func detectFormat(file string) string {
+	switch strings.ToLower(path.Ext(file)) {
+	case ".toml":
+		return "toml"
+	case ".ini":
+		return "ini"
+	case ".properties":
+		return "properties"
+	case ".env":
+		return "dotenv"
+	case ".jsonl":
+		return "jsonl"
+	default:
+		return "yaml"
+	}
+}
+
+// parseINI flattens an INI document into vars. Section names become the
+// first path element; keys outside any section sit at the root.
+func parseINI(data []byte, vars map[string]string) error {
+	section := ""
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, ";") || strings.HasPrefix(line, "#") {
+			continue
+		}
+		if strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]") {
+			section = strings.TrimSpace(line[1 : len(line)-1])
+			continue
+		}
+		idx := strings.Index(line, "=")
+		if idx < 1 {
+			return fmt.Errorf("invalid ini line %d: %s", i+1, line)
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		vars[path.Join("/", section, key)] = unquote(value)
+	}
+	return nil
+}
+
+// parseProperties flattens a Java-style properties file into vars. Dots in
+// key names become path separators, so `db.pool.size` is exposed as
+// /db/pool/size.
+func parseProperties(data []byte, vars map[string]string) error {
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "!") {
+			continue
+		}
+		idx := strings.IndexAny(line, "=:")
+		if idx < 1 {
+			return fmt.Errorf("invalid properties line %d: %s", i+1, line)
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		vars[path.Join("/", strings.Replace(key, ".", "/", -1))] = value
+	}
+	return nil
+}
+
+// parseDotenv flattens a dotenv file into vars. An optional `export ` prefix
+// is stripped and quoted values are unquoted.
+func parseDotenv(data []byte, vars map[string]string) error {
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		idx := strings.Index(line, "=")
+		if idx < 1 {
+			return fmt.Errorf("invalid dotenv line %d: %s", i+1, line)
+		}
+		key := strings.TrimSpace(line[:idx])
+		value := strings.TrimSpace(line[idx+1:])
+		vars[path.Join("/", key)] = unquote(value)
+	}
+	return nil
+}
+
+// yamlDocSep matches a YAML document separator line.
+var yamlDocSep = regexp.MustCompile(`(?m)^---\s*$`)
+
+// splitYAMLDocs splits a YAML stream on document separators, dropping empty
+// documents. A stream with a single leading separator still counts as one
+// document, so ordinary YAML files are unaffected.
+func splitYAMLDocs(data []byte) []string {
+	docs := []string{}
+	for _, doc := range yamlDocSep.Split(string(data), -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		docs = append(docs, doc)
+	}
+	return docs
+}
+
+// docName returns the path element a document is indexed under: the value of
+// the configured identifying field (a path like metadata/name) when present,
+// otherwise the document's position in the stream. Named documents let
+// templates address Kubernetes-style manifests stably as files are reordered.
+func docName(doc interface{}, docKey string, position int) string {
+	if docKey != "" {
+		node := doc
+		for _, part := range strings.Split(strings.Trim(docKey, "/"), "/") {
+			switch m := node.(type) {
+			case map[interface{}]interface{}:
+				node = m[part]
+			case map[string]interface{}:
+				node = m[part]
+			default:
+				node = nil
+			}
+			if node == nil {
+				break
+			}
+		}
+		if name, ok := node.(string); ok && name != "" {
+			return name
+		}
+	}
+	return strconv.Itoa(position)
+}
+
+// parseMultiYAML flattens each document of a multi-doc YAML stream into vars
+// under its own root, named by docName.
+func parseMultiYAML(docs []string, vars map[string]string, docKey string) error {
+	for position, doc := range docs {
+		var node interface{}
+		if err := yaml.Unmarshal([]byte(doc), &node); err != nil {
+			return err
+		}
+		if node == nil {
+			continue
+		}
+		if err := nodeWalk(node, path.Join("/", docName(node, docKey, position)), vars); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseJSONL flattens a JSON Lines file into vars, one document per
+// non-empty line, each under its own root named by docName.
+func parseJSONL(data []byte, vars map[string]string, docKey string) error {
+	position := 0
+	for i, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		var node interface{}
+		if err := yaml.Unmarshal([]byte(line), &node); err != nil {
+			return fmt.Errorf("invalid jsonl line %d: %s", i+1, err)
+		}
+		if err := nodeWalk(node, path.Join("/", docName(node, docKey, position)), vars); err != nil {
+			return err
+		}
+		position++
+	}
+	return nil
+}
+
+// unquote strips one level of matching single or double quotes.
+func unquote(s string) string {
+	if len(s) >= 2 {
+		if (s[0] == '"' && s[len(s)-1] == '"') || (s[0] == '\'' && s[len(s)-1] == '\'') {
+			return s[1 : len(s)-1]
+		}
+	}
+	return s
+}
@@ -0,0 +1,77 @@
+package backends
+
+import (
+	"time"
+
+	"github.com/abtreece/confd/metrics"
+)
+
+func init() {
+	metrics.Register("confd_backend_requests_total", "Number of backend requests by backend, operation, and result.", "counter")
+	metrics.Register("confd_backend_request_duration_seconds_sum", "Total time spent in backend requests.", "counter")
+	metrics.Register("confd_backend_request_duration_seconds_count", "Number of timed backend requests.", "counter")
+}
+
+// instrumentedClient wraps a StoreClient and records request metrics labeled
+// with the backend name.
+type instrumentedClient struct {
+	client  StoreClient
+	backend string
+}
+
+// wrapInstrumentation wraps client so every call is counted and timed under
+// the given backend label.
+func wrapInstrumentation(client StoreClient, backend string) StoreClient {
+	return &instrumentedClient{client: client, backend: backend}
+}
+
+func (c *instrumentedClient) observe(operation string, start time.Time, err error) {
+	result := "success"
+	if err != nil {
+		result = "error"
+	}
+	labels := map[string]string{"backend": c.backend, "operation": operation, "result": result}
+	metrics.Inc("confd_backend_requests_total", labels)
+	timed := map[string]string{"backend": c.backend, "operation": operation}
+	metrics.Add("confd_backend_request_duration_seconds_sum", timed, time.Since(start).Seconds())
+	metrics.Inc("confd_backend_request_duration_seconds_count", timed)
+}
+
+func (c *instrumentedClient) GetValues(keys []string) (map[string]string, error) {
+	start := time.Now()
+	vars, err := c.client.GetValues(keys)
+	c.observe("get_values", start, err)
+	return vars, err
+}
+
+func (c *instrumentedClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	start := time.Now()
+	index, err := c.client.WatchPrefix(prefix, keys, waitIndex, stopChan)
+	c.observe("watch_prefix", start, err)
+	return index, err
+}
+
+// Revision forwards to the wrapped client.
+func (c *instrumentedClient) Revision() uint64 {
+	return revisionOf(c.client)
+}
+
+// KeyMeta reports per-key metadata from the wrapped client.
+func (c *instrumentedClient) KeyMeta(key string) (uint64, string, uint64, bool) {
+	return keyMetaOf(c.client, key)
+}
+
+// IssueCert forwards to the wrapped client.
+func (c *instrumentedClient) IssueCert(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	return issueCertOf(c.client, path, data)
+}
+
+// AcquireSemaphore forwards to the wrapped client.
+func (c *instrumentedClient) AcquireSemaphore(prefix string, limit int) (func() error, error) {
+	return acquireSemaphoreOf(c.client, prefix, limit)
+}
+
+// KeyExpiry forwards to the wrapped client.
+func (c *instrumentedClient) KeyExpiry(key string) (time.Time, bool) {
+	return keyExpiryOf(c.client, key)
+}
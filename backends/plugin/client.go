@@ -0,0 +1,116 @@
+/*
+Package plugin implements a StoreClient backed by an external plugin
+process. The plugin executable is launched as a subprocess and spoken to
+over JSON-RPC on its stdin and stdout, in the spirit of
+hashicorp/go-plugin, so proprietary config stores can be integrated
+without forking confd. The plugin must export a "Plugin" service with
+Ping, GetValues, and WatchPrefix methods.
+*/
+package plugin
+
+import (
+	"errors"
+	"io"
+	"net/rpc"
+	"net/rpc/jsonrpc"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/abtreece/confd/log"
+)
+
+// GetValuesRequest is the argument to the Plugin.GetValues method.
+type GetValuesRequest struct {
+	Keys []string
+}
+
+// WatchPrefixRequest is the argument to the Plugin.WatchPrefix method.
+type WatchPrefixRequest struct {
+	Prefix    string
+	Keys      []string
+	WaitIndex uint64
+}
+
+// WatchPrefixResponse is the reply from the Plugin.WatchPrefix method.
+type WatchPrefixResponse struct {
+	Index uint64
+}
+
+// Client talks to a backend plugin subprocess.
+type Client struct {
+	cmd *exec.Cmd
+	rpc *rpc.Client
+}
+
+// pipePair joins the plugin's stdout and stdin into the ReadWriteCloser
+// net/rpc expects.
+type pipePair struct {
+	io.ReadCloser
+	io.WriteCloser
+}
+
+func (p pipePair) Close() error {
+	p.ReadCloser.Close()
+	return p.WriteCloser.Close()
+}
+
+// NewPluginClient starts the plugin command and performs a Ping health
+// check. The command is split on whitespace so arguments can be passed.
+func NewPluginClient(command string) (*Client, error) {
+	if command == "" {
+		return nil, errors.New("no plugin command configured")
+	}
+	parts := strings.Fields(command)
+	cmd := exec.Command(parts[0], parts[1:]...)
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	log.Info("Started backend plugin " + parts[0])
+
+	c := &Client{
+		cmd: cmd,
+		rpc: jsonrpc.NewClient(pipePair{ReadCloser: stdout, WriteCloser: stdin}),
+	}
+	var pong string
+	if err := c.rpc.Call("Plugin.Ping", "", &pong); err != nil {
+		c.Close()
+		return nil, errors.New("plugin health check failed: " + err.Error())
+	}
+	return c, nil
+}
+
+// Close shuts down the RPC connection and waits for the plugin to exit.
+func (c *Client) Close() error {
+	c.rpc.Close()
+	return c.cmd.Wait()
+}
+
+// GetValues queries the plugin for the given keys.
+func (c *Client) GetValues(keys []string) (map[string]string, error) {
+	vars := make(map[string]string)
+	err := c.rpc.Call("Plugin.GetValues", GetValuesRequest{Keys: keys}, &vars)
+	return vars, err
+}
+
+// WatchPrefix delegates the blocking watch to the plugin, returning early
+// when the stop channel closes.
+func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	var resp WatchPrefixResponse
+	call := c.rpc.Go("Plugin.WatchPrefix", WatchPrefixRequest{Prefix: prefix, Keys: keys, WaitIndex: waitIndex}, &resp, nil)
+	select {
+	case <-call.Done:
+		return resp.Index, call.Error
+	case <-stopChan:
+		return waitIndex, nil
+	}
+}
@@ -1,7 +1,11 @@
 package redis
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"strconv"
 	"strings"
@@ -20,21 +24,34 @@ type watchResponse struct {
 type Client struct {
 	client    redis.Conn
 	machines  []string
+	username  string
 	password  string
 	separator string
+	tlsConfig *tls.Config
+	tracking  bool
 	psc       redis.PubSubConn
 	pscChan   chan watchResponse
 }
 
 // Iterate through `machines`, trying to connect to each in turn.
 // Returns the first successful connection or the last error encountered.
-// Assumes that `machines` is non-empty.
-func tryConnect(machines []string, password string, timeout bool) (redis.Conn, int, error) {
+// Assumes that `machines` is non-empty. Nodes may carry a redis:// or
+// rediss:// scheme; the latter dials TLS with the client's TLS config. With
+// a username set, ACL authentication is performed after the dial.
+func tryConnect(machines []string, username, password string, timeout bool, tlsConfig *tls.Config) (redis.Conn, int, error) {
 	var err error
 	for _, address := range machines {
 		var conn redis.Conn
 		var db int
 
+		useTLS := false
+		if strings.HasPrefix(address, "rediss://") {
+			useTLS = true
+			address = strings.TrimPrefix(address, "rediss://")
+		} else {
+			address = strings.TrimPrefix(address, "redis://")
+		}
+
 		idx := strings.Index(address, "/")
 		if idx != -1 {
 			// a database is provided
@@ -66,7 +83,17 @@ func tryConnect(machines []string, password string, timeout bool) (redis.Conn, i
 			}
 		}
 
-		if password != "" {
+		if useTLS {
+			dialops = append(dialops, redis.DialUseTLS(true))
+			if tlsConfig != nil {
+				dialops = append(dialops, redis.DialTLSConfig(tlsConfig))
+			}
+		}
+
+		// ACL authentication needs the two-argument AUTH, which this redis
+		// client does not issue itself; plain password auth stays with the
+		// dial option.
+		if password != "" && username == "" {
 			dialops = append(dialops, redis.DialPassword(password))
 		}
 
@@ -75,11 +102,42 @@ func tryConnect(machines []string, password string, timeout bool) (redis.Conn, i
 		if err != nil {
 			continue
 		}
+		if username != "" {
+			if _, err = conn.Do("AUTH", username, password); err != nil {
+				conn.Close()
+				continue
+			}
+		}
 		return conn, db, nil
 	}
 	return nil, 0, err
 }
 
+// newTLSConfig builds the TLS config for rediss:// nodes from the usual
+// client cert and CA flags.
+func newTLSConfig(cert, key, caCert string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if cert != "" && key != "" {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	if caCert != "" {
+		ca, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("no certificates found in " + caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return tlsConfig, nil
+}
+
 // Retrieves a connected redis client from the client wrapper.
 // Existing connections will be tested with a PING command before being returned. Tries to reconnect once if necessary.
 // Returns the established redis connection or the error encountered.
@@ -98,7 +156,7 @@ func (c *Client) connectedClient() (redis.Conn, error) {
 	// Existing client could have been deleted by previous block
 	if c.client == nil {
 		var err error
-		c.client, _, err = tryConnect(c.machines, c.password, true)
+		c.client, _, err = tryConnect(c.machines, c.username, c.password, true, c.tlsConfig)
 		if err != nil {
 			return nil, err
 		}
@@ -108,15 +166,21 @@ func (c *Client) connectedClient() (redis.Conn, error) {
 }
 
 // NewRedisClient returns an *redis.Client with a connection to named machines.
-// It returns an error if a connection to the cluster cannot be made.
-func NewRedisClient(machines []string, password string, separator string) (*Client, error) {
+// It returns an error if a connection to the cluster cannot be made. With a
+// username set, ACL authentication is used; cert, key, and caCert configure
+// TLS for rediss:// nodes. With tracking enabled, watch mode uses server
+// invalidation messages instead of keyspace notifications.
+func NewRedisClient(machines []string, username, password, separator, cert, key, caCert string, tracking bool) (*Client, error) {
 	if separator == "" {
 		separator = "/"
 	}
 	log.Debug(fmt.Sprintf("Redis Separator: %#v", separator))
-	var err error
-	clientWrapper := &Client{machines: machines, password: password, separator: separator, client: nil, pscChan: make(chan watchResponse), psc: redis.PubSubConn{Conn: nil}}
-	clientWrapper.client, _, err = tryConnect(machines, password, true)
+	tlsConfig, err := newTLSConfig(cert, key, caCert)
+	if err != nil {
+		return nil, err
+	}
+	clientWrapper := &Client{machines: machines, username: username, password: password, separator: separator, tlsConfig: tlsConfig, tracking: tracking, client: nil, pscChan: make(chan watchResponse), psc: redis.PubSubConn{Conn: nil}}
+	clientWrapper.client, _, err = tryConnect(machines, username, password, true, tlsConfig)
 	return clientWrapper, err
 }
 
@@ -240,7 +304,7 @@ func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, sto
 
 	go func() {
 		if c.psc.Conn == nil {
-			rClient, db, err := tryConnect(c.machines, c.password, false)
+			rClient, db, err := tryConnect(c.machines, c.username, c.password, false, c.tlsConfig)
 
 			if err != nil {
 				c.psc = redis.PubSubConn{Conn: nil}
@@ -267,6 +331,11 @@ func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, sto
 								break
 							}
 						}
+					case redis.Message:
+						// Invalidation message redirected from client
+						// tracking: the watched subtree changed.
+						log.Debug(fmt.Sprintf("Redis invalidation: %s %s\n", n.Channel, n.Data))
+						c.pscChan <- watchResponse{1, nil}
 					case redis.Subscription:
 						log.Debug(fmt.Sprintf("Redis Subscription: %s %s %d\n", n.Kind, n.Channel, n.Count))
 						if n.Count == 0 {
@@ -281,7 +350,28 @@ func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, sto
 				}
 			}()
 
-			c.psc.PSubscribe("__keyspace@" + strconv.Itoa(db) + "__:" + c.transform(prefix) + "*")
+			if c.tracking {
+				// Client tracking in broadcast mode redirects invalidation
+				// messages for the watched prefix to this subscriber, which
+				// works even where keyspace notifications are disabled.
+				id, err := redis.Int64(c.psc.Conn.Do("CLIENT", "ID"))
+				if err != nil {
+					c.pscChan <- watchResponse{0, err}
+					return
+				}
+				main, err := c.connectedClient()
+				if err != nil {
+					c.pscChan <- watchResponse{0, err}
+					return
+				}
+				if _, err := main.Do("CLIENT", "TRACKING", "on", "REDIRECT", id, "BCAST", "PREFIX", c.transform(prefix)); err != nil {
+					c.pscChan <- watchResponse{0, err}
+					return
+				}
+				c.psc.Subscribe("__redis__:invalidate")
+			} else {
+				c.psc.PSubscribe("__keyspace@" + strconv.Itoa(db) + "__:" + c.transform(prefix) + "*")
+			}
 		}
 	}()
 
@@ -0,0 +1,105 @@
+package backends
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/abtreece/confd/log"
+)
+
+// retryClient wraps a StoreClient and retries failed GetValues calls with
+// exponential backoff and jitter. Every backend honors the same retry
+// settings from confd.toml and the CLI flags instead of having ad-hoc retry
+// behavior. Watches are not retried here; the processors already reconnect
+// watch loops on error.
+type retryClient struct {
+	client       StoreClient
+	maxRetries   int
+	baseDelay    time.Duration
+	maxDelay     time.Duration
+	jitterFactor float64
+}
+
+// wrapRetry wraps client according to config. The client is returned
+// unchanged when no retries are configured.
+func wrapRetry(client StoreClient, config Config) StoreClient {
+	if config.MaxRetries <= 0 {
+		return client
+	}
+	baseDelay := time.Duration(config.RetryBaseDelay) * time.Millisecond
+	if baseDelay <= 0 {
+		baseDelay = 500 * time.Millisecond
+	}
+	maxDelay := time.Duration(config.RetryMaxDelay) * time.Millisecond
+	if maxDelay <= 0 {
+		maxDelay = 5 * time.Second
+	}
+	return &retryClient{
+		client:       client,
+		maxRetries:   config.MaxRetries,
+		baseDelay:    baseDelay,
+		maxDelay:     maxDelay,
+		jitterFactor: config.RetryJitterFactor,
+	}
+}
+
+// backoff returns the delay before the given retry attempt.
+func (c *retryClient) backoff(attempt int) time.Duration {
+	delay := c.baseDelay << uint(attempt)
+	if delay > c.maxDelay || delay <= 0 {
+		delay = c.maxDelay
+	}
+	if c.jitterFactor > 0 {
+		jitter := time.Duration((rand.Float64()*2 - 1) * c.jitterFactor * float64(delay))
+		delay += jitter
+	}
+	return delay
+}
+
+func (c *retryClient) GetValues(keys []string) (map[string]string, error) {
+	var lastErr error
+	for attempt := 0; ; attempt++ {
+		vars, err := c.client.GetValues(keys)
+		if err == nil {
+			return vars, nil
+		}
+		lastErr = err
+		if attempt >= c.maxRetries {
+			break
+		}
+		delay := c.backoff(attempt)
+		log.Warning(fmt.Sprintf("Backend request failed (attempt %d of %d): %s. Retrying in %s", attempt+1, c.maxRetries+1, err.Error(), delay))
+		time.Sleep(delay)
+	}
+	return nil, lastErr
+}
+
+func (c *retryClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	return c.client.WatchPrefix(prefix, keys, waitIndex, stopChan)
+}
+
+// Revision forwards to the wrapped client.
+func (c *retryClient) Revision() uint64 {
+	return revisionOf(c.client)
+}
+
+// KeyMeta reports per-key metadata from the wrapped client.
+func (c *retryClient) KeyMeta(key string) (uint64, string, uint64, bool) {
+	return keyMetaOf(c.client, key)
+}
+
+// IssueCert forwards to the wrapped client.
+func (c *retryClient) IssueCert(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	return issueCertOf(c.client, path, data)
+}
+
+// AcquireSemaphore forwards to the wrapped client.
+func (c *retryClient) AcquireSemaphore(prefix string, limit int) (func() error, error) {
+	return acquireSemaphoreOf(c.client, prefix, limit)
+}
+
+// KeyExpiry forwards to the wrapped client.
+func (c *retryClient) KeyExpiry(key string) (time.Time, bool) {
+	return keyExpiryOf(c.client, key)
+}
@@ -0,0 +1,138 @@
+package backends
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// rewriteRule maps one template-side key namespace to a backend path. A
+// plain rule rewrites a path prefix; a rule whose pattern starts with "~"
+// is a regular expression with $1-style references in the replacement.
+type rewriteRule struct {
+	prefix  string
+	re      *regexp.Regexp
+	replace string
+}
+
+// rewriteClient translates between the key layout templates were written
+// for and a reorganized backend keyspace, so legacy templates keep working
+// after keys move without editing every template.
+type rewriteClient struct {
+	client StoreClient
+	rules  []rewriteRule
+}
+
+// wrapRewrite layers the key_rewrites table on top of client. An empty
+// table returns the client unchanged.
+func wrapRewrite(client StoreClient, config Config) (StoreClient, error) {
+	if len(config.KeyRewrites) == 0 {
+		return client, nil
+	}
+	rules := make([]rewriteRule, 0, len(config.KeyRewrites))
+	for from, to := range config.KeyRewrites {
+		if strings.HasPrefix(from, "~") {
+			re, err := regexp.Compile(strings.TrimPrefix(from, "~"))
+			if err != nil {
+				return nil, fmt.Errorf("invalid key_rewrites pattern %q: %s", from, err)
+			}
+			rules = append(rules, rewriteRule{re: re, replace: to})
+			continue
+		}
+		rules = append(rules, rewriteRule{prefix: strings.TrimSuffix(from, "/"), replace: strings.TrimSuffix(to, "/")})
+	}
+	// Longest prefix first so the most specific rule wins; regex rules keep
+	// their relative order after the prefixes.
+	sort.SliceStable(rules, func(i, j int) bool { return len(rules[i].prefix) > len(rules[j].prefix) })
+	return &rewriteClient{client: client, rules: rules}, nil
+}
+
+// rewrite maps a template-side key to its backend path using the first
+// matching rule. Keys no rule matches pass through unchanged.
+func (c *rewriteClient) rewrite(key string) string {
+	for _, rule := range c.rules {
+		if rule.re != nil {
+			if rule.re.MatchString(key) {
+				return rule.re.ReplaceAllString(key, rule.replace)
+			}
+			continue
+		}
+		if key == rule.prefix || strings.HasPrefix(key, rule.prefix+"/") {
+			return rule.replace + key[len(rule.prefix):]
+		}
+	}
+	return key
+}
+
+// restore maps a backend path under one of the rewritten request prefixes
+// back to the template-side namespace it was requested as.
+func restore(key string, back map[string]string) string {
+	best := ""
+	for rewritten := range back {
+		if len(rewritten) <= len(best) {
+			continue
+		}
+		if key == rewritten || strings.HasPrefix(key, rewritten+"/") {
+			best = rewritten
+		}
+	}
+	if best == "" {
+		return key
+	}
+	return back[best] + key[len(best):]
+}
+
+func (c *rewriteClient) GetValues(keys []string) (map[string]string, error) {
+	rewritten := make([]string, len(keys))
+	back := make(map[string]string)
+	for i, key := range keys {
+		rewritten[i] = c.rewrite(key)
+		if rewritten[i] != key {
+			back[rewritten[i]] = key
+		}
+	}
+	vars, err := c.client.GetValues(rewritten)
+	if err != nil || len(back) == 0 {
+		return vars, err
+	}
+	restored := make(map[string]string, len(vars))
+	for key, value := range vars {
+		restored[restore(key, back)] = value
+	}
+	return restored, nil
+}
+
+func (c *rewriteClient) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
+	rewritten := make([]string, len(keys))
+	for i, key := range keys {
+		rewritten[i] = c.rewrite(key)
+	}
+	return c.client.WatchPrefix(c.rewrite(prefix), rewritten, waitIndex, stopChan)
+}
+
+// Revision forwards to the wrapped client.
+func (c *rewriteClient) Revision() uint64 {
+	return revisionOf(c.client)
+}
+
+// KeyMeta reports per-key metadata for the rewritten backend path.
+func (c *rewriteClient) KeyMeta(key string) (uint64, string, uint64, bool) {
+	return keyMetaOf(c.client, c.rewrite(key))
+}
+
+// IssueCert forwards to the wrapped client.
+func (c *rewriteClient) IssueCert(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	return issueCertOf(c.client, path, data)
+}
+
+// AcquireSemaphore forwards to the wrapped client.
+func (c *rewriteClient) AcquireSemaphore(prefix string, limit int) (func() error, error) {
+	return acquireSemaphoreOf(c.client, prefix, limit)
+}
+
+// KeyExpiry reports expiry metadata for the rewritten backend path.
+func (c *rewriteClient) KeyExpiry(key string) (time.Time, bool) {
+	return keyExpiryOf(c.client, c.rewrite(key))
+}
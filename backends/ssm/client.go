@@ -1,22 +1,33 @@
 package ssm
 
 import (
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"net/url"
 	"os"
+	"strings"
 
+	"github.com/abtreece/confd/backends/awssession"
 	"github.com/abtreece/confd/log"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/ec2metadata"
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sqs"
 	"github.com/aws/aws-sdk-go/service/ssm"
 )
 
 type Client struct {
-	client *ssm.SSM
+	client   *ssm.SSM
+	sqs      *sqs.SQS
+	queueURL string
 }
 
-func New() (*Client, error) {
+// New returns an SSM client. watchQueue, when non-empty, is the URL of an
+// SQS queue receiving EventBridge Parameter Store change events; it enables
+// WatchPrefix instead of the watch falling back to blocking forever.
+func New(proxyURL, roleARN, roleExternalID, watchQueue string) (*Client, error) {
 
 	// Attempt to get AWS Region from ec2metadata. Should determine how to
 	// shorten ec2metadata client timeout so it fails fast if not on EC2.
@@ -27,17 +38,15 @@ func New() (*Client, error) {
 	conf := aws.NewConfig().WithRegion(region)
 
 	// Create a session to share configuration, and load external configuration.
-	sess := session.Must(session.NewSessionWithOptions(
-		session.Options{
-			SharedConfigState: session.SharedConfigEnable,
-			Config:            *conf,
-		},
-	))
+	sess, err := awssession.New(conf, roleARN, roleExternalID)
+	if err != nil {
+		return nil, err
+	}
 
 	log.Debug(fmt.Sprintf("Region: %s", aws.StringValue(sess.Config.Region)))
 
 	// Fail early, if no credentials can be found
-	_, err := sess.Config.Credentials.Get()
+	_, err = sess.Config.Credentials.Get()
 	if err != nil {
 		return nil, err
 	}
@@ -52,10 +61,24 @@ func New() (*Client, error) {
 	} else {
 		c = nil
 	}
+	if proxyURL != "" {
+		proxy, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		if c == nil {
+			c = &aws.Config{}
+		}
+		c.HTTPClient = &http.Client{Transport: &http.Transport{Proxy: http.ProxyURL(proxy)}}
+	}
 
 	// Create the service's client with the session.
 	svc := ssm.New(sess, c)
-	return &Client{svc}, nil
+	client := &Client{client: svc, queueURL: watchQueue}
+	if watchQueue != "" {
+		client.sqs = sqs.New(sess, c)
+	}
+	return client, nil
 }
 
 // GetValues retrieves the values for the given keys from AWS SSM Parameter Store
@@ -114,8 +137,68 @@ func (c *Client) getParameter(name string) (map[string]string, error) {
 	return parameters, nil
 }
 
-// WatchPrefix is not implemented
+// changeEvent is the slice of an EventBridge Parameter Store change event
+// the watch cares about.
+type changeEvent struct {
+	Detail struct {
+		Name      string `json:"name"`
+		Operation string `json:"operation"`
+	} `json:"detail"`
+}
+
+// WatchPrefix waits for Parameter Store change events delivered to the
+// configured SQS queue and returns when a changed parameter falls under one
+// of the watched keys. Without a queue configured the watch blocks until
+// stopped, the previous behavior.
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
+	if c.sqs == nil {
+		<-stopChan
+		return 0, nil
+	}
+	for {
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		default:
+		}
+		resp, err := c.sqs.ReceiveMessage(&sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(c.queueURL),
+			MaxNumberOfMessages: aws.Int64(10),
+			WaitTimeSeconds:     aws.Int64(20),
+		})
+		if err != nil {
+			return waitIndex, err
+		}
+		relevant := false
+		for _, m := range resp.Messages {
+			if m.ReceiptHandle != nil {
+				_, derr := c.sqs.DeleteMessage(&sqs.DeleteMessageInput{
+					QueueUrl:      aws.String(c.queueURL),
+					ReceiptHandle: m.ReceiptHandle,
+				})
+				if derr != nil {
+					log.Debug(fmt.Sprintf("Failed to delete SQS message: %s", derr))
+				}
+			}
+			if m.Body == nil {
+				continue
+			}
+			var event changeEvent
+			if jerr := json.Unmarshal([]byte(*m.Body), &event); jerr != nil || event.Detail.Name == "" {
+				// An unparseable event still signals change; re-reading the
+				// parameters is cheap compared to missing an update.
+				relevant = true
+				continue
+			}
+			for _, k := range keys {
+				if strings.HasPrefix(event.Detail.Name, k) {
+					relevant = true
+					break
+				}
+			}
+		}
+		if relevant {
+			return waitIndex + 1, nil
+		}
+	}
 }
@@ -1,22 +1,31 @@
 package vault
 
 import (
+	"crypto/md5"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"net/url"
 	"path"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/abtreece/confd/log"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/sts"
 	vaultapi "github.com/hashicorp/vault/api"
 )
 
 // Client is a wrapper around the vault client
 type Client struct {
-	client *vaultapi.Client
+	client        *vaultapi.Client
+	watchInterval time.Duration
 }
 
 // get a
@@ -57,15 +66,29 @@ func authenticate(c *vaultapi.Client, authType string, params map[string]string)
 		if authType == "app-role" {
 			path = "approle"
 		}
+		if authType == "aws-iam" {
+			path = "aws"
+		}
 	}
 	url := fmt.Sprintf("/auth/%s/login", path)
 
 	switch authType {
 	case "app-role":
-		secret, err = c.Logical().Write(url, map[string]interface{}{
-			"role_id":   getParameter("role-id", params),
-			"secret_id": getParameter("secret-id", params),
-		})
+		data := map[string]interface{}{
+			"role_id": getParameter("role-id", params),
+		}
+		// A wrapped secret-id is a response-wrapping token; it is unwrapped
+		// via sys/wrapping/unwrap to obtain the real secret_id first.
+		if wrapped := params["wrapped-secret-id"]; wrapped != "" {
+			unwrapped, uerr := c.Logical().Unwrap(wrapped)
+			if uerr != nil {
+				return fmt.Errorf("unable to unwrap secret-id: %s", uerr)
+			}
+			data["secret_id"] = unwrapped.Data["secret_id"]
+		} else {
+			data["secret_id"] = getParameter("secret-id", params)
+		}
+		secret, err = c.Logical().Write(url, data)
 	case "app-id":
 		secret, err = c.Logical().Write(url, map[string]interface{}{
 			"app_id":  getParameter("app-id", params),
@@ -76,7 +99,24 @@ func authenticate(c *vaultapi.Client, authType string, params map[string]string)
 			"token": getParameter("token", params),
 		})
 	case "token":
-		c.SetToken(getParameter("token", params))
+		token := getParameter("token", params)
+		// With unwrap set, the supplied token is a response-wrapping token
+		// from a trusted orchestrator and the real client token is inside.
+		if params["unwrap"] == "true" {
+			unwrapped, uerr := c.Logical().Unwrap(token)
+			if uerr != nil {
+				return fmt.Errorf("unable to unwrap token: %s", uerr)
+			}
+			switch {
+			case unwrapped.Auth != nil && unwrapped.Auth.ClientToken != "":
+				token = unwrapped.Auth.ClientToken
+			case unwrapped.Data["token"] != nil:
+				token = fmt.Sprintf("%v", unwrapped.Data["token"])
+			default:
+				return errors.New("unwrapped response contains no token")
+			}
+		}
+		c.SetToken(token)
 		secret, err = c.Logical().Read("/auth/token/lookup-self")
 	case "userpass":
 		username, password := getParameter("username", params), getParameter("password", params)
@@ -93,7 +133,22 @@ func authenticate(c *vaultapi.Client, authType string, params map[string]string)
 			"role": getParameter("role-id", params),
 		})
 	case "cert":
-		secret, err = c.Logical().Write(url, map[string]interface{}{})
+		// The TLS client certificate from the transport does the
+		// authenticating; an optional role-id selects the cert role.
+		data := map[string]interface{}{}
+		if name := params["role-id"]; name != "" {
+			data["name"] = name
+		}
+		secret, err = c.Logical().Write(url, data)
+	case "aws-iam":
+		data, err := iamLoginData(params)
+		if err != nil {
+			return err
+		}
+		secret, err = c.Logical().Write(url, data)
+		if err != nil {
+			return err
+		}
 	}
 
 	if err != nil {
@@ -116,7 +171,43 @@ func authenticate(c *vaultapi.Client, authType string, params map[string]string)
 	return nil
 }
 
-func getConfig(address, cert, key, caCert string) (*vaultapi.Config, error) {
+// iamLoginData builds the signed sts:GetCallerIdentity request Vault's AWS
+// auth method verifies for IAM logins. Credentials come from the standard
+// AWS chain (environment, shared config, or instance/task role), so
+// EC2/EKS workloads can authenticate without static secrets.
+func iamLoginData(params map[string]string) (map[string]interface{}, error) {
+	sess, err := session.NewSessionWithOptions(session.Options{
+		SharedConfigState: session.SharedConfigEnable,
+	})
+	if err != nil {
+		return nil, err
+	}
+	stsSvc := sts.New(sess)
+	req, _ := stsSvc.GetCallerIdentityRequest(&sts.GetCallerIdentityInput{})
+	if err := req.Sign(); err != nil {
+		return nil, err
+	}
+	headers, err := json.Marshal(req.HTTPRequest.Header)
+	if err != nil {
+		return nil, err
+	}
+	body, err := ioutil.ReadAll(req.GetBody())
+	if err != nil {
+		return nil, err
+	}
+	data := map[string]interface{}{
+		"iam_http_request_method": req.HTTPRequest.Method,
+		"iam_request_url":         base64.StdEncoding.EncodeToString([]byte(req.HTTPRequest.URL.String())),
+		"iam_request_headers":     base64.StdEncoding.EncodeToString(headers),
+		"iam_request_body":        base64.StdEncoding.EncodeToString(body),
+	}
+	if role := params["role-id"]; role != "" {
+		data["role"] = role
+	}
+	return data, nil
+}
+
+func getConfig(address, cert, key, caCert, proxyURL string) (*vaultapi.Config, error) {
 	conf := vaultapi.DefaultConfig()
 	conf.Address = address
 
@@ -140,7 +231,16 @@ func getConfig(address, cert, key, caCert string) (*vaultapi.Config, error) {
 		tlsConfig.RootCAs = caCertPool
 	}
 
+	proxy := http.ProxyFromEnvironment
+	if proxyURL != "" {
+		u, err := url.Parse(proxyURL)
+		if err != nil {
+			return nil, err
+		}
+		proxy = http.ProxyURL(u)
+	}
 	conf.HttpClient.Transport = &http.Transport{
+		Proxy:           proxy,
 		TLSClientConfig: tlsConfig,
 	}
 
@@ -154,7 +254,7 @@ func New(address, authType string, params map[string]string) (*Client, error) {
 		return nil, errors.New("you have to set the auth type when using the vault backend")
 	}
 	log.Info("Vault authentication backend set to %s", authType)
-	conf, err := getConfig(address, params["cert"], params["key"], params["caCert"])
+	conf, err := getConfig(address, params["cert"], params["key"], params["caCert"], params["proxy"])
 
 	if err != nil {
 		return nil, err
@@ -168,19 +268,58 @@ func New(address, authType string, params map[string]string) (*Client, error) {
 	if err := authenticate(c, authType, params); err != nil {
 		return nil, err
 	}
-	return &Client{c}, nil
+	watchInterval := 30 * time.Second
+	if v := params["watch-interval"]; v != "" {
+		seconds, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid watch-interval %q: %s", v, err)
+		}
+		if seconds > 0 {
+			watchInterval = time.Duration(seconds) * time.Second
+		}
+	}
+	return &Client{client: c, watchInterval: watchInterval}, nil
+}
+
+// IssueCert asks a Vault PKI secrets engine to issue a certificate. The
+// path is the issue endpoint (e.g. "pki/issue/web") and data carries the
+// request parameters (common_name, ttl, alt_names). The raw secret data is
+// returned: certificate, private_key, issuing_ca and ca_chain.
+func (c *Client) IssueCert(path string, data map[string]interface{}) (map[string]interface{}, error) {
+	secret, err := c.client.Logical().Write(path, data)
+	if err != nil {
+		return nil, err
+	}
+	if secret == nil || secret.Data == nil {
+		return nil, fmt.Errorf("vault returned no data for %s", path)
+	}
+	return secret.Data, nil
 }
 
-// GetValues queries Vault for keys prefixed by prefix.
+// GetValues queries Vault for keys prefixed by prefix. A key may carry a
+// "?version=N" suffix to pin a KV v2 secret to a specific version; such keys
+// are read directly instead of being walked.
 func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	branches := make(map[string]bool)
+	versions := make(map[string]string)
 	for _, key := range keys {
+		if base, version, ok := splitVersion(key); ok {
+			branches[base] = true
+			versions[base] = version
+			continue
+		}
 		walkTree(c, key, branches)
 	}
 	vars := make(map[string]string)
 	for key := range branches {
 		log.Debug("getting %s from vault", key)
-		resp, err := c.client.Logical().Read(key)
+		var resp *vaultapi.Secret
+		var err error
+		if version, ok := versions[key]; ok {
+			resp, err = c.client.Logical().ReadWithData(key, map[string][]string{"version": {version}})
+		} else {
+			resp, err = c.client.Logical().Read(key)
+		}
 
 		if err != nil {
 			log.Debug("there was an error extracting %s", key)
@@ -198,6 +337,11 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 				js, _ := json.Marshal(kvData)
 				vars[key] = string(js)
 				flatten(key, kvData, vars)
+				// expose the secret metadata (version, created_time, ...)
+				// under <key>/metadata so templates can inspect it
+				for mk, mv := range m {
+					vars[path.Join(key, "metadata", mk)] = fmt.Sprintf("%v", mv)
+				}
 			}
 		} else if val, ok := isKV(resp.Data); ok {
 			vars[key] = val
@@ -212,6 +356,16 @@ func (c *Client) GetValues(keys []string) (map[string]string, error) {
 	return vars, nil
 }
 
+// splitVersion splits a "?version=N" suffix off a key. It reports whether a
+// version was present.
+func splitVersion(key string) (string, string, bool) {
+	i := strings.Index(key, "?version=")
+	if i < 0 {
+		return key, "", false
+	}
+	return key[:i], key[i+len("?version="):], true
+}
+
 // isKV checks if a given map has only one key of type string
 // if so, returns the value of that key
 func isKV(data map[string]interface{}) (string, bool) {
@@ -289,8 +443,73 @@ func walkTree(c *Client, key string, branches map[string]bool) error {
 	return nil
 }
 
-// WatchPrefix - not implemented at the moment
+// secretVersion returns a string identifying the current state of the secret
+// at key. On KV v2 mounts this is the current_version from the secret
+// metadata; on KV v1 mounts, which have no versions, it falls back to a
+// digest of the secret data.
+func (c *Client) secretVersion(key string) (string, error) {
+	if i := strings.Index(key, "/data/"); i >= 0 {
+		metaKey := key[:i] + "/metadata/" + key[i+len("/data/"):]
+		resp, err := c.client.Logical().Read(metaKey)
+		if err != nil {
+			return "", err
+		}
+		if resp != nil && resp.Data != nil {
+			if version, ok := resp.Data["current_version"]; ok {
+				return fmt.Sprintf("%v", version), nil
+			}
+		}
+	}
+	resp, err := c.client.Logical().Read(key)
+	if err != nil {
+		return "", err
+	}
+	if resp == nil || resp.Data == nil {
+		return "", nil
+	}
+	js, _ := json.Marshal(resp.Data)
+	return fmt.Sprintf("%x", md5.Sum(js)), nil
+}
+
+// watchDigest combines the versions of all watched secrets into one digest.
+func (c *Client) watchDigest(keys []string) (string, error) {
+	h := md5.New()
+	for _, key := range keys {
+		base, _, _ := splitVersion(key)
+		version, err := c.secretVersion(base)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "%s=%s;", base, version)
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// WatchPrefix polls the KV v2 metadata of the watched secrets and returns
+// when any secret version changes. Vault has no native watch API, so this is
+// the closest equivalent to the blocking watches other backends provide.
 func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, stopChan chan bool) (uint64, error) {
-	<-stopChan
-	return 0, nil
+	if waitIndex == 0 {
+		return 1, nil
+	}
+	last, err := c.watchDigest(keys)
+	if err != nil {
+		return waitIndex, err
+	}
+	ticker := time.NewTicker(c.watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stopChan:
+			return waitIndex, nil
+		case <-ticker.C:
+			current, err := c.watchDigest(keys)
+			if err != nil {
+				return waitIndex, err
+			}
+			if current != last {
+				return waitIndex + 1, nil
+			}
+		}
+	}
 }
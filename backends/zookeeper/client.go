@@ -1,6 +1,11 @@
 package zookeeper
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"net"
 	"path/filepath"
 	"strings"
 	"time"
@@ -14,11 +19,61 @@ type Client struct {
 	client *zk.Conn
 }
 
-func NewZookeeperClient(machines []string) (*Client, error) {
-	c, _, err := zk.Connect(machines, time.Second) //*10)
+// tlsDialer returns a zk.Dialer that establishes TLS connections to
+// ZooKeeper 3.5+ ensembles using the given client cert and CA material.
+func tlsDialer(cert, key, caCert string) (zk.Dialer, error) {
+	tlsConfig := &tls.Config{}
+	if cert != "" && key != "" {
+		clientCert, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{clientCert}
+	}
+	if caCert != "" {
+		ca, err := ioutil.ReadFile(caCert)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("Cannot parse CA certificate " + caCert)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	return func(network, address string, timeout time.Duration) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err == nil {
+			c := tlsConfig.Clone()
+			c.ServerName = host
+			return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, address, c)
+		}
+		return tls.DialWithDialer(&net.Dialer{Timeout: timeout}, network, address, tlsConfig)
+	}, nil
+}
+
+func NewZookeeperClient(machines []string, username, password, cert, key, caCert string) (*Client, error) {
+	var (
+		c   *zk.Conn
+		err error
+	)
+	if cert != "" || caCert != "" {
+		dialer, derr := tlsDialer(cert, key, caCert)
+		if derr != nil {
+			return nil, derr
+		}
+		c, _, err = zk.Connect(machines, time.Second, zk.WithDialer(dialer))
+	} else {
+		c, _, err = zk.Connect(machines, time.Second) //*10)
+	}
 	if err != nil {
 		panic(err)
 	}
+	if username != "" && password != "" {
+		if err := c.AddAuth("digest", []byte(username+":"+password)); err != nil {
+			return nil, err
+		}
+	}
 	return &Client{c}, nil
 }
 
@@ -84,18 +139,28 @@ type watchResponse struct {
 
 func (c *Client) watch(key string, respChan chan watchResponse, cancelRoutine chan bool) {
 	_, _, keyEventCh, err := c.client.GetW(key)
-	if err != nil {
-		respChan <- watchResponse{0, err}
+	if err == zk.ErrNoNode {
+		// The node does not exist yet. An exists watch fires on creation,
+		// so a root that services register ephemeral children under is
+		// picked up as soon as it appears.
+		_, _, keyEventCh, err = c.client.ExistsW(key)
 	}
-	_, _, childEventCh, err := c.client.ChildrenW(key)
 	if err != nil {
 		respChan <- watchResponse{0, err}
+		return
+	}
+	// A nil channel blocks forever, so a missing node simply has no child
+	// watch until it is recreated and watched again.
+	var childEventCh <-chan zk.Event
+	if _, _, ch, cerr := c.client.ChildrenW(key); cerr == nil {
+		childEventCh = ch
 	}
 
 	for {
 		select {
 		case e := <-keyEventCh:
-			if e.Type == zk.EventNodeDataChanged {
+			switch e.Type {
+			case zk.EventNodeDataChanged, zk.EventNodeCreated, zk.EventNodeDeleted:
 				respChan <- watchResponse{1, e.Err}
 			}
 		case e := <-childEventCh:
@@ -126,8 +191,19 @@ func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, sto
 	cancelRoutine := make(chan bool)
 	defer close(cancelRoutine)
 
-	//watch all subfolders for changes
+	//watch the requested roots themselves so child nodes appearing under a
+	//currently empty node - the ephemeral service registration pattern -
+	//still emit a change even when no keys matched yet
 	watchMap := make(map[string]string)
+	for _, v := range keys {
+		if _, ok := watchMap[v]; !ok {
+			watchMap[v] = ""
+			log.Debug("Watching: " + v)
+			go c.watch(v, respChan, cancelRoutine)
+		}
+	}
+
+	//watch all subfolders for changes
 	for k, _ := range entries {
 		for _, v := range keys {
 			if strings.HasPrefix(k, v) {
@@ -147,8 +223,11 @@ func (c *Client) WatchPrefix(prefix string, keys []string, waitIndex uint64, sto
 	for k, _ := range entries {
 		for _, v := range keys {
 			if strings.HasPrefix(k, v) {
-				log.Debug("Watching: " + k)
-				go c.watch(k, respChan, cancelRoutine)
+				if _, ok := watchMap[k]; !ok {
+					watchMap[k] = ""
+					log.Debug("Watching: " + k)
+					go c.watch(k, respChan, cancelRoutine)
+				}
 				break
 			}
 		}
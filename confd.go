@@ -3,18 +3,64 @@ package main
 import (
 	"flag"
 	"fmt"
+	"math/rand"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"reflect"
 	"runtime"
+	"sort"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/abtreece/confd/backends"
 	"github.com/abtreece/confd/log"
+	"github.com/abtreece/confd/metrics"
 	"github.com/abtreece/confd/resource/template"
 )
 
 func main() {
+	// Seed the global source once so splay offsets, retry and backoff
+	// jitter, and weighted shuffles actually differ between instances;
+	// unseeded they are identical fleet-wide and spread nothing.
+	rand.Seed(time.Now().UnixNano())
+	// Subcommands are stripped from the argument list before flag parsing so
+	// the existing global flags keep working unchanged.
+	var subcommand string
+	if len(os.Args) > 1 && os.Args[1] == "convert" {
+		unconverted, err := runConvert(os.Args[2:], os.Stdout)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if unconverted > 0 {
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+	var execArgs []string
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "diff", "export", "init", "test":
+			subcommand = os.Args[1]
+			os.Args = append(os.Args[:1], os.Args[2:]...)
+		case "exec":
+			// Everything after "--" is the wrapped command; flags before it
+			// are parsed as usual.
+			subcommand = "exec"
+			var flagArgs []string
+			flagArgs, execArgs = splitExecArgs(os.Args[2:])
+			os.Args = append(os.Args[:1], flagArgs...)
+		}
+	}
+	exportMode := subcommand == "export"
 	flag.Parse()
+	if subcommand == "init" {
+		if err := runInit(config.ConfDir, config.Backend, config.BackendNodes); err != nil {
+			log.Fatal(err.Error())
+		}
+		os.Exit(0)
+	}
 	if config.PrintVersion {
 		fmt.Printf("confd %s (Git SHA: %s, Go Version: %s)\n", Version, GitSHA, runtime.Version())
 		os.Exit(0)
@@ -25,44 +71,212 @@ func main() {
 
 	log.Info("Starting confd")
 
+	metrics.SetBuildInfo(map[string]string{
+		"version":   Version,
+		"commit":    GitSHA,
+		"backend":   config.Backend,
+		"goversion": runtime.Version(),
+	})
+
+	if config.MetricsListen != "" {
+		go func() {
+			if err := startOpsServer(); err != nil {
+				log.Error(err.Error())
+			}
+		}()
+	}
+
+	if subcommand == "test" {
+		failures, err := template.RunTests(config.TemplateConfig, filepath.Join(config.ConfDir, "tests"), os.Stdout)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if failures > 0 {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
 	storeClient, err := backends.New(config.BackendsConfig)
 	if err != nil {
 		log.Fatal(err.Error())
 	}
 
+	if exportMode {
+		if err := export(storeClient, config.Prefix, config.ExportFormat, os.Stdout); err != nil {
+			log.Fatal(err.Error())
+		}
+		os.Exit(0)
+	}
+
+	if subcommand == "diff" {
+		config.TemplateConfig.StoreClient = storeClient
+		drift, err := template.Diff(config.TemplateConfig, os.Stdout)
+		if err != nil {
+			log.Fatal(err.Error())
+		}
+		if drift > 0 {
+			log.Info(fmt.Sprintf("%d resource(s) out of sync", drift))
+			os.Exit(2)
+		}
+		os.Exit(0)
+	}
+
 	config.TemplateConfig.StoreClient = storeClient
-	if config.OneTime {
-		if err := template.Process(config.TemplateConfig); err != nil {
+	if subcommand == "exec" {
+		if err := runExec(execArgs); err != nil {
 			log.Fatal(err.Error())
 		}
 		os.Exit(0)
 	}
+	if config.OneTime {
+		updated, err := template.ProcessWithStatus(config.TemplateConfig)
+		// The extended scheme gives deploy tooling distinct codes to branch
+		// on; the default scheme keeps the historical 0/1 behavior.
+		if config.ExitCodes != "extended" {
+			if err != nil {
+				log.Fatal(err.Error())
+			}
+			os.Exit(0)
+		}
+		if err != nil {
+			log.Error(err.Error())
+			switch template.Categorize(err) {
+			case template.ErrCheckFailed:
+				os.Exit(4)
+			case template.ErrBackendUnreachable:
+				os.Exit(5)
+			default:
+				os.Exit(1)
+			}
+		}
+		if updated {
+			os.Exit(3)
+		}
+		os.Exit(0)
+	}
 
-	stopChan := make(chan bool)
-	doneChan := make(chan bool)
 	errChan := make(chan error, 10)
-
-	var processor template.Processor
-	switch {
-	case config.Watch:
-		processor = template.WatchProcessor(config.TemplateConfig, stopChan, doneChan, errChan)
-	default:
-		processor = template.IntervalProcessor(config.TemplateConfig, stopChan, doneChan, errChan, config.Interval)
+	startProcessor := func() (chan bool, chan bool) {
+		stopChan := make(chan bool)
+		doneChan := make(chan bool)
+		var processor template.Processor
+		switch {
+		case config.Watch:
+			processor = template.WatchProcessor(config.TemplateConfig, stopChan, doneChan, errChan)
+		default:
+			processor = template.IntervalProcessor(config.TemplateConfig, stopChan, doneChan, errChan)
+		}
+		go processor.Process()
+		return stopChan, doneChan
 	}
+	stopChan, doneChan := startProcessor()
 
-	go processor.Process()
+	// With an SRV interval set, the record is re-resolved periodically so
+	// backend nodes follow DNS instead of going stale. Node changes are
+	// handed to the main loop, which rebuilds the store client there.
+	srvChan := make(chan []string)
+	if config.SRVRecord != "" && config.SRVInterval > 0 {
+		go watchSRVNodes(srvChan)
+	}
 
 	signalChan := make(chan os.Signal, 1)
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 	for {
 		select {
 		case err := <-errChan:
 			log.Error(err.Error())
+		case nodes := <-srvChan:
+			stopChan, doneChan = applySRVNodes(nodes, stopChan, doneChan, startProcessor)
 		case s := <-signalChan:
-			log.Info(fmt.Sprintf("Captured %v. Exiting...", s))
-			close(doneChan)
+			if s == syscall.SIGHUP {
+				stopChan, doneChan = reload(stopChan, doneChan, startProcessor)
+				continue
+			}
+			log.Info(fmt.Sprintf("Captured %v. Draining in-flight renders...", s))
+			close(stopChan)
+			select {
+			case <-doneChan:
+			case <-time.After(time.Duration(config.DrainTimeout) * time.Second):
+				log.Warning("Drain timeout reached. Exiting...")
+			case s := <-signalChan:
+				log.Info(fmt.Sprintf("Captured %v. Exiting immediately...", s))
+			}
+			os.Exit(0)
 		case <-doneChan:
 			os.Exit(0)
 		}
 	}
 }
+
+// watchSRVNodes re-resolves the SRV record every SRVInterval seconds and
+// sends the resolved node list on srvChan whenever it differs from the
+// current one. Resolution failures are logged and the current nodes kept.
+func watchSRVNodes(srvChan chan []string) {
+	for range time.Tick(time.Duration(config.SRVInterval) * time.Second) {
+		nodes, err := resolveSRVNodes()
+		if err != nil {
+			log.Error("Cannot get nodes from SRV records " + err.Error())
+			continue
+		}
+		sort.Strings(nodes)
+		current := append([]string(nil), config.BackendNodes...)
+		sort.Strings(current)
+		if !reflect.DeepEqual(nodes, current) {
+			srvChan <- nodes
+		}
+	}
+}
+
+// applySRVNodes swaps in a freshly resolved backend node list: the store
+// client is rebuilt and the processor restarted, the same cycle a SIGHUP
+// reload runs. On failure the previous client and processor keep running.
+func applySRVNodes(nodes []string, stopChan, doneChan chan bool, startProcessor func() (chan bool, chan bool)) (chan bool, chan bool) {
+	log.Info("SRV nodes changed to " + strings.Join(nodes, ", ") + ". Rebuilding store client...")
+	previous := config.BackendNodes
+	config.BackendNodes = nodes
+	storeClient, err := backends.New(config.BackendsConfig)
+	if err != nil {
+		log.Error("SRV update failed, keeping previous store client: " + err.Error())
+		config.BackendNodes = previous
+		return stopChan, doneChan
+	}
+	config.TemplateConfig.StoreClient = storeClient
+	close(stopChan)
+	select {
+	case <-doneChan:
+	case <-time.After(time.Duration(config.DrainTimeout) * time.Second):
+		log.Warning("Drain timeout reached while applying SRV nodes.")
+	}
+	return startProcessor()
+}
+
+// reload handles SIGHUP: backend settings are re-read from the config file
+// and environment, the store client is rebuilt when they changed, and the
+// processor is restarted so every resource re-renders. On any failure the
+// existing client and processor keep running.
+func reload(stopChan, doneChan chan bool, startProcessor func() (chan bool, chan bool)) (chan bool, chan bool) {
+	log.Info("Captured SIGHUP. Reloading configuration...")
+	previous := config.BackendsConfig
+	if err := initConfig(); err != nil {
+		log.Error("Reload failed, keeping previous configuration: " + err.Error())
+		return stopChan, doneChan
+	}
+	if !reflect.DeepEqual(previous, config.BackendsConfig) {
+		log.Info("Backend configuration changed. Rebuilding store client...")
+		storeClient, err := backends.New(config.BackendsConfig)
+		if err != nil {
+			log.Error("Reload failed, keeping previous store client: " + err.Error())
+			config.BackendsConfig = previous
+			return stopChan, doneChan
+		}
+		config.TemplateConfig.StoreClient = storeClient
+	}
+	close(stopChan)
+	select {
+	case <-doneChan:
+	case <-time.After(time.Duration(config.DrainTimeout) * time.Second):
+		log.Warning("Drain timeout reached while reloading.")
+	}
+	return startProcessor()
+}
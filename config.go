@@ -24,54 +24,146 @@ type BackendsConfig = backends.Config
 type Config struct {
 	TemplateConfig
 	BackendsConfig
-	Interval       int    `toml:"interval"`
-	SRVDomain      string `toml:"srv_domain"`
-	SRVRecord      string `toml:"srv_record"`
-	LogLevel       string `toml:"log-level"`
-	Watch          bool   `toml:"watch"`
+	DrainTimeout        int    `toml:"drain_timeout"`
+	ExecReloadSignal    string `toml:"exec_reload_signal"`
+	ExitCodes           string `toml:"exit_codes"`
+	SRVDomain           string `toml:"srv_domain"`
+	SRVRecord           string `toml:"srv_record"`
+	SRVInterval         int    `toml:"srv_interval"`
+	LogLevel            string `toml:"log-level"`
+	MetricsListen       string `toml:"metrics_listen"`
+	MetricsAllow        string `toml:"metrics_allowlist"`
+	MetricsTLSCert      string `toml:"metrics_tls_cert"`
+	MetricsTLSKey       string `toml:"metrics_tls_key"`
+	MetricsClientCA     string `toml:"metrics_client_ca"`
+	MetricsAuthUser     string `toml:"metrics_auth_user"`
+	MetricsAuthPassword string `toml:"metrics_auth_password"`
+	MetricsBearerToken  string `toml:"metrics_bearer_token"`
+	Watch               bool   `toml:"watch"`
+	// Environment selects a prefix layering profile from the environments
+	// table, applied to every resource that does not opt out.
+	Environment string `toml:"environment"`
+	// Environments maps profile names to the prefix layers they apply,
+	// e.g. prod = ["/global", "/prod"].
+	Environments   map[string][]string `toml:"environments"`
 	PrintVersion   bool
 	ConfigFile     string
 	OneTime        bool
 	ClientInsecure bool
+	ExportFormat   string
 }
 
 var config Config
 
 func init() {
 	flag.StringVar(&config.AuthToken, "auth-token", "", "Auth bearer token to use")
+	flag.StringVar(&config.AuthTokenFile, "auth-token-file", "", "path to a file containing the auth bearer token, re-read when the file changes")
 	flag.StringVar(&config.Backend, "backend", "etcd", "backend to use")
 	flag.BoolVar(&config.BasicAuth, "basic-auth", false, "Use Basic Auth to authenticate (only used with -backend=consul and -backend=etcd)")
 	flag.StringVar(&config.ClientCaKeys, "client-ca-keys", "", "client ca keys")
 	flag.StringVar(&config.ClientCert, "client-cert", "", "the client cert")
 	flag.StringVar(&config.ClientKey, "client-key", "", "the client key")
 	flag.BoolVar(&config.ClientInsecure, "client-insecure", false, "Allow connections to SSL sites without certs (only used with -backend=etcd)")
+	flag.StringVar(&config.ClientServerName, "client-server-name", "", "server name used for TLS SNI and certificate verification instead of the connection hostname (only used with -backend=etcd)")
+	flag.BoolVar(&config.ClientSkipHostnameVerify, "client-skip-hostname-verify", false, "skip the TLS hostname match but still verify the certificate chain against the CA (only used with -backend=etcd)")
+	flag.StringVar(&config.Datacenter, "datacenter", "", "the datacenter to query (only used with -backend=consul)")
+	flag.StringVar(&config.Namespace, "namespace", "", "the namespace to query (only used with -backend=consul, Consul Enterprise)")
+	flag.StringVar(&config.Partition, "partition", "", "the admin partition to query (only used with -backend=consul)")
+	flag.BoolVar(&config.NoRecurse, "no-recurse", false, "fetch only the exact keys listed instead of recursing each prefix (only used with -backend=consul)")
 	flag.StringVar(&config.ConfDir, "confdir", "/etc/confd", "confd conf directory")
 	flag.StringVar(&config.ConfigFile, "config-file", "/etc/confd/confd.toml", "the confd config file")
 	flag.Var(&config.YAMLFile, "file", "the YAML file to watch for changes (only used with -backend=file)")
 	flag.StringVar(&config.Filter, "filter", "*", "files filter (only used with -backend=file)")
+	flag.StringVar(&config.WatchStrategy, "watch-strategy", "inotify", "how to watch for file changes: inotify or poll (only used with -backend=file)")
+	flag.StringVar(&config.MergePolicy, "merge-policy", "last", "policy for conflicting keys across files: first, last, or error (only used with -backend=file)")
+	flag.StringVar(&config.FileFormat, "file-format", "", "force a parser for all files: yaml, json, toml, ini, properties, dotenv, or jsonl; detected per file by extension when unset (only used with -backend=file)")
+	flag.StringVar(&config.FileDocKey, "file-doc-key", "", "path of a field naming each document in multi-doc YAML and JSON Lines files, e.g. metadata/name; documents are indexed by position when unset (only used with -backend=file)")
+	flag.StringVar(&config.Environment, "environment", "", "name of a prefix layering profile from the environments table in the config file, applied to every resource")
+	flag.StringVar(&config.EnvCasing, "env-casing", "", "how key paths map to environment variable names: upper, lower, or preserve (only used with -backend=env)")
+	flag.StringVar(&config.EnvSeparator, "env-separator", "", "string standing in for / in environment variable names, _ by default (only used with -backend=env)")
+	flag.StringVar(&config.EnvStripPrefix, "env-strip-prefix", "", "prefix removed from environment variable names before mapping them to key paths, e.g. APP_ (only used with -backend=env)")
+	flag.StringVar(&config.ExportFormat, "format", "json", "the output format for the export command (json, dotenv, or consul)")
+	flag.StringVar(&config.DecryptCmd, "decrypt-cmd", "", "command run to decrypt backend values that look encrypted (armored age or PGP blocks, or an enc: prefix); ciphertext on stdin, plaintext on stdout, e.g. 'age -d -i /etc/confd/key.txt'")
+	flag.IntVar(&config.DrainTimeout, "drain-timeout", 30, "seconds to wait for in-flight renders and reloads to finish on shutdown")
+	flag.StringVar(&config.ExecReloadSignal, "exec-reload-signal", "", "signal sent to the command run by the exec subcommand when a re-render changed a dest (e.g. HUP, empty to disable)")
+	flag.StringVar(&config.ExitCodes, "exit-codes", "", "exit code scheme for -onetime: extended exits 0 on success without changes, 3 with changes applied, 4 on check_cmd failure, 5 when the backend is unreachable, and 1 on other errors")
 	flag.IntVar(&config.Interval, "interval", 600, "backend polling interval")
+	flag.IntVar(&config.IntervalMin, "interval-min", 0, "floor in seconds for adaptive polling; with -interval-max it shortens the poll interval after changes (0 disables adaptive polling)")
+	flag.IntVar(&config.IntervalMax, "interval-max", 0, "ceiling in seconds for adaptive polling during quiet periods")
+	flag.IntVar(&config.IntervalBackoffMax, "interval-backoff-max", 0, "cap in seconds for the exponential backoff between runs after consecutive failures (0 keeps the fixed interval)")
+	flag.IntVar(&config.MaxFailures, "max-consecutive-failures", 0, "exit with a distinct code after this many consecutive failed runs, for supervised environments (0 to retry forever)")
+	flag.IntVar(&config.Splay, "splay", 0, "randomize each interval tick by up to this many seconds to avoid thundering herds (0 to disable)")
 	flag.BoolVar(&config.KeepStageFile, "keep-stage-file", false, "keep staged files")
 	flag.StringVar(&config.LogLevel, "log-level", "", "level which confd should log messages")
+	flag.StringVar(&config.MetricsListen, "metrics-listen", "", "address to serve /metrics on (e.g. :2112, empty to disable)")
+	flag.StringVar(&config.MetricsAllow, "metrics-allowlist", "", "comma-separated glob patterns of metric names to expose (empty exposes all)")
+	flag.StringVar(&config.MetricsTLSCert, "metrics-tls-cert", "", "TLS certificate for the metrics listener (serves plaintext when empty)")
+	flag.StringVar(&config.MetricsTLSKey, "metrics-tls-key", "", "TLS key for the metrics listener")
+	flag.StringVar(&config.MetricsClientCA, "metrics-client-ca", "", "CA bundle used to require and verify client certificates on the metrics listener")
+	flag.StringVar(&config.MetricsAuthUser, "metrics-auth-user", "", "username required via basic auth on the metrics listener")
+	flag.StringVar(&config.MetricsAuthPassword, "metrics-auth-password", "", "password required via basic auth on the metrics listener")
+	flag.StringVar(&config.MetricsBearerToken, "metrics-bearer-token", "", "bearer token required on the metrics listener")
 	flag.Var(&config.BackendNodes, "node", "list of backend nodes")
 	flag.BoolVar(&config.Noop, "noop", false, "only show pending changes")
+	flag.Var(&config.FileRoots, "file-root", "directory templates may read local files from with readFile/fileExists (repeatable); file reads are disabled when unset")
+	flag.Var(&config.ResourceFilter, "resource", "glob matching the resource TOML filename or dest; only matching resources are processed (repeatable)")
+	flag.Var(&config.ResourceSkip, "skip-resource", "glob matching the resource TOML filename or dest; matching resources are skipped (repeatable)")
+	flag.IntVar(&config.RecheckInterval, "recheck-interval", 0, "seconds between drift checks of dest files against the last rendered state; drifted files are re-rendered (0 to disable, mainly useful with -watch)")
 	flag.BoolVar(&config.OneTime, "onetime", false, "run once and exit")
 	flag.StringVar(&config.Prefix, "prefix", "", "key path prefix")
 	flag.BoolVar(&config.PrintVersion, "version", false, "print version and exit")
 	flag.StringVar(&config.Scheme, "scheme", "http", "the backend URI scheme for nodes retrieved from DNS SRV records (http or https)")
 	flag.StringVar(&config.SRVDomain, "srv-domain", "", "the name of the resource record")
 	flag.StringVar(&config.SRVRecord, "srv-record", "", "the SRV record to search for backends nodes. Example: _etcd-client._tcp.example.com")
+	flag.StringVar(&config.SSMWatchQueue, "ssm-watch-queue", "", "URL of an SQS queue receiving EventBridge Parameter Store change events, enables -watch (only used with -backend=ssm)")
+	flag.IntVar(&config.SRVInterval, "srv-interval", 0, "seconds between SRV record re-resolutions; on a node change the backend client is rebuilt (0 to disable)")
 	flag.BoolVar(&config.SyncOnly, "sync-only", false, "sync without check_cmd and reload_cmd")
+	flag.BoolVar(&config.Strict, "strict", false, "fail the render when a key listed in keys returns no values")
+	flag.StringVar(&config.NotifyWebhook, "notify-webhook", "", "URL to POST a JSON render event to after each render")
+	flag.StringVar(&config.LocalOverrides, "local-overrides", "", "YAML file whose keys override backend values with highest precedence")
+	flag.StringVar(&config.AuditLog, "audit-log", "", "path to an append-only JSONL audit log of every file change (empty to disable)")
+	flag.IntVar(&config.AuditLogSize, "audit-log-max-size", 10, "audit log size in MB at which it is rotated to <path>.1")
+	flag.IntVar(&config.MaxKeys, "max-keys", 0, "maximum number of keys a resource may fetch from the backend (0 for no limit)")
+	flag.IntVar(&config.MaxValueBytes, "max-value-bytes", 0, "maximum size in bytes of a single backend value (0 for no limit)")
+	flag.IntVar(&config.MaxTotalBytes, "max-total-bytes", 0, "maximum total bytes a resource may fetch from the backend (0 for no limit)")
+	flag.StringVar(&config.SecretsTmpfs, "secrets-tmpfs", "/dev/shm", "memory-backed directory where resources marked sensitive stage their renders")
+	flag.IntVar(&config.WatchWatchdog, "watch-watchdog", 0, "seconds of watch inactivity before a stalled watch goroutine is restarted (0 to disable)")
+	flag.StringVar(&config.ManifestFile, "manifest-file", "", "file recording every dest confd manages, enabling cleanup of unmanaged files")
+	flag.BoolVar(&config.Cleanup, "cleanup", false, "remove files recorded in the manifest that no resource defines anymore (honors -noop as dry run)")
+	flag.IntVar(&config.Debounce, "debounce", 0, "seconds of quiet to wait after a watched change before rendering (0 to disable)")
+	flag.IntVar(&config.DebounceMax, "debounce-max", 0, "maximum seconds a render may be debounced after the first buffered change (defaults to 10x -debounce)")
+	flag.Var(&config.TemplateDirs, "template-dir", "template search path, may be given multiple times and is searched in order (defaults to confdir/templates)")
 	flag.StringVar(&config.AuthType, "auth-type", "", "Vault auth backend type to use (only used with -backend=vault)")
 	flag.StringVar(&config.AppID, "app-id", "", "Vault app-id to use with the app-id backend (only used with -backend=vault and auth-type=app-id)")
 	flag.StringVar(&config.UserID, "user-id", "", "Vault user-id to use with the app-id backend (only used with -backend=value and auth-type=app-id)")
 	flag.StringVar(&config.RoleID, "role-id", "", "Vault role-id to use with the AppRole, Kubernetes backends (only used with -backend=vault and either auth-type=app-role or auth-type=kubernetes)")
 	flag.StringVar(&config.SecretID, "secret-id", "", "Vault secret-id to use with the AppRole backend (only used with -backend=vault and auth-type=app-role)")
+	flag.StringVar(&config.WrappedSecretID, "wrapped-secret-id", "", "Vault response-wrapping token holding the AppRole secret-id, unwrapped before login (only used with -backend=vault and auth-type=app-role)")
+	flag.BoolVar(&config.VaultUnwrap, "vault-unwrap", false, "treat -auth-token as a Vault response-wrapping token and unwrap it before use (only used with -backend=vault and auth-type=token)")
 	flag.StringVar(&config.Path, "path", "", "Vault mount path of the auth method (only used with -backend=vault)")
 	flag.StringVar(&config.Table, "table", "", "the name of the DynamoDB table (only used with -backend=dynamodb)")
 	flag.StringVar(&config.Separator, "separator", "", "the separator to replace '/' with when looking up keys in the backend, prefixed '/' will also be removed (only used with -backend=redis)")
+	flag.BoolVar(&config.RedisClientTracking, "redis-client-tracking", false, "watch via client tracking invalidation messages instead of keyspace notifications (only used with -backend=redis)")
 	flag.StringVar(&config.Username, "username", "", "the username to authenticate as (only used with vault and etcd backends)")
 	flag.StringVar(&config.Password, "password", "", "the password to authenticate with (only used with vault and etcd backends)")
+	flag.StringVar(&config.PasswordFile, "password-file", "", "path to a file containing the password, re-read when the file changes")
 	flag.BoolVar(&config.Watch, "watch", false, "enable watch support")
+	flag.IntVar(&config.RequestTimeout, "request-timeout", 0, "backend request timeout in seconds (0 to disable)")
+	flag.IntVar(&config.CircuitThreshold, "circuit-threshold", 0, "consecutive backend failures before the circuit breaker opens (0 to disable)")
+	flag.IntVar(&config.CircuitOpenDuration, "circuit-open-duration", 10, "seconds the circuit breaker stays open before a half-open probe")
+	flag.IntVar(&config.EtcdDialTimeout, "etcd-dial-timeout", 0, "etcd dial timeout in seconds (0 for the client default, only used with -backend=etcd)")
+	flag.IntVar(&config.EtcdKeepAliveTime, "etcd-keepalive-time", 0, "interval in seconds between gRPC keepalive pings (0 for the client default, only used with -backend=etcd)")
+	flag.IntVar(&config.EtcdKeepAliveTimeout, "etcd-keepalive-timeout", 0, "seconds to wait for a keepalive ping response (0 for the client default, only used with -backend=etcd)")
+	flag.BoolVar(&config.EtcdPermitWithoutStream, "etcd-permit-without-stream", false, "send keepalive pings even without active RPC streams (only used with -backend=etcd)")
+	flag.IntVar(&config.MaxRetries, "max-retries", 0, "number of times a failed backend read is retried (0 to disable)")
+	flag.IntVar(&config.RetryBaseDelay, "retry-base-delay", 500, "initial retry backoff delay in milliseconds")
+	flag.IntVar(&config.RetryMaxDelay, "retry-max-delay", 5000, "maximum retry backoff delay in milliseconds")
+	flag.Float64Var(&config.RetryJitterFactor, "retry-jitter-factor", 0.1, "fraction by which each retry delay is randomized")
+	flag.IntVar(&config.VaultWatchInterval, "vault-watch-interval", 30, "seconds between KV metadata polls when watching the vault backend")
+	flag.StringVar(&config.PluginCmd, "plugin-cmd", "", "command to launch the backend plugin (only used with -backend=plugin)")
+	flag.StringVar(&config.ProxyURL, "proxy-url", "", "proxy URL (http, https, or socks5 scheme) for backend connections; HTTP_PROXY/HTTPS_PROXY are honored when unset")
+	flag.StringVar(&config.RoleARN, "role-arn", "", "IAM role to assume for AWS-backed backends (only used with -backend=dynamodb and -backend=ssm)")
+	flag.StringVar(&config.RoleExternalID, "role-external-id", "", "external ID to pass when assuming -role-arn")
 }
 
 // initConfig initializes the confd configuration by first setting defaults,
@@ -96,6 +188,24 @@ func initConfig() error {
 		}
 	}
 
+	// Apply drop-in configuration fragments from <config-file>.d in lexical
+	// order so multiple packages can each own a piece of the configuration.
+	dropIns, err := filepath.Glob(config.ConfigFile + ".d/*.toml")
+	if err != nil {
+		return err
+	}
+	for _, dropIn := range dropIns {
+		log.Debug("Loading " + dropIn)
+		configBytes, err := ioutil.ReadFile(dropIn)
+		if err != nil {
+			return err
+		}
+		_, err = toml.Decode(string(configBytes), &config)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Update config from environment variables.
 	processEnv()
 
@@ -110,20 +220,10 @@ func initConfig() error {
 	// Update BackendNodes from SRV records.
 	if config.Backend != "env" && config.SRVRecord != "" {
 		log.Info("SRV record set to " + config.SRVRecord)
-		srvNodes, err := getBackendNodesFromSRV(config.SRVRecord)
+		srvNodes, err := resolveSRVNodes()
 		if err != nil {
 			return errors.New("Cannot get nodes from SRV records " + err.Error())
 		}
-
-		switch config.Backend {
-		case "etcd":
-			vsm := make([]string, len(srvNodes))
-			for i, v := range srvNodes {
-				vsm[i] = config.Scheme + "://" + v
-			}
-			srvNodes = vsm
-		}
-
 		config.BackendNodes = srvNodes
 	}
 	if len(config.BackendNodes) == 0 {
@@ -151,7 +251,10 @@ func initConfig() error {
 	if config.Watch {
 		unsupportedBackends := map[string]bool{
 			"dynamodb": true,
-			"ssm":      true,
+		}
+		// The ssm backend supports watch only with a change event queue.
+		if config.SSMWatchQueue == "" {
+			unsupportedBackends["ssm"] = true
 		}
 
 		if unsupportedBackends[config.Backend] {
@@ -163,11 +266,46 @@ func initConfig() error {
 	if config.Backend == "dynamodb" && config.Table == "" {
 		return errors.New("No DynamoDB table configured")
 	}
+	switch config.ExitCodes {
+	case "", "default", "extended":
+	default:
+		return fmt.Errorf("invalid exit-codes scheme: %s (must be default or extended)", config.ExitCodes)
+	}
+
+	// Resolve the selected environment profile into the prefix layers every
+	// resource inherits, so one confd.toml serves dev, stage, and prod.
+	if config.Environment != "" {
+		prefixes, ok := config.Environments[config.Environment]
+		if !ok {
+			return fmt.Errorf("environment %q is not defined in the environments table", config.Environment)
+		}
+		config.TemplateConfig.EnvironmentPrefixes = prefixes
+	}
+
 	config.ConfigDir = filepath.Join(config.ConfDir, "conf.d")
 	config.TemplateDir = filepath.Join(config.ConfDir, "templates")
 	return nil
 }
 
+// resolveSRVNodes resolves the configured SRV record into a backend node
+// list, applying the URI scheme for backends that expect one. Targets are
+// joined with net.JoinHostPort so IPv6 literals come out bracketed.
+func resolveSRVNodes() ([]string, error) {
+	srvNodes, err := getBackendNodesFromSRV(config.SRVRecord)
+	if err != nil {
+		return nil, err
+	}
+	switch config.Backend {
+	case "etcd":
+		vsm := make([]string, len(srvNodes))
+		for i, v := range srvNodes {
+			vsm[i] = config.Scheme + "://" + v
+		}
+		srvNodes = vsm
+	}
+	return srvNodes, nil
+}
+
 func getBackendNodesFromSRV(record string) ([]string, error) {
 	nodes := make([]string, 0)
 
@@ -11,19 +11,30 @@ func TestInitConfigDefaultConfig(t *testing.T) {
 	log.SetLevel("warn")
 	want := Config{
 		BackendsConfig: BackendsConfig{
-			Backend:      "etcd",
-			BackendNodes: []string{"http://127.0.0.1:2379"},
-			Scheme:       "http",
-			Filter:       "*",
+			Backend:             "etcd",
+			BackendNodes:        []string{"http://127.0.0.1:2379"},
+			Scheme:              "http",
+			Filter:              "*",
+			MergePolicy:         "last",
+			WatchStrategy:       "inotify",
+			CircuitOpenDuration: 10,
+			RetryBaseDelay:      500,
+			RetryMaxDelay:       5000,
+			VaultWatchInterval:  30,
+			RetryJitterFactor:   0.1,
 		},
 		TemplateConfig: TemplateConfig{
-			ConfDir:     "/etc/confd",
-			ConfigDir:   "/etc/confd/conf.d",
-			TemplateDir: "/etc/confd/templates",
-			Noop:        false,
+			ConfDir:      "/etc/confd",
+			ConfigDir:    "/etc/confd/conf.d",
+			TemplateDir:  "/etc/confd/templates",
+			Noop:         false,
+			AuditLogSize: 10,
+			SecretsTmpfs: "/dev/shm",
+			Interval:     600,
 		},
-		ConfigFile: "/etc/confd/confd.toml",
-		Interval:   600,
+		ConfigFile:   "/etc/confd/confd.toml",
+		DrainTimeout: 30,
+		ExportFormat: "json",
 	}
 	if err := initConfig(); err != nil {
 		t.Errorf(err.Error())
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+
+	"github.com/abtreece/confd/log"
+)
+
+// convertRule rewrites one consul-template construct into the equivalent
+// confd template syntax.
+type convertRule struct {
+	pattern *regexp.Regexp
+	replace string
+}
+
+// consulTemplateRules covers the common consul-template constructs that have
+// a direct confd equivalent. Key paths gain the leading slash confd expects.
+var consulTemplateRules = []convertRule{
+	{regexp.MustCompile(`\{\{\s*keyOrDefault\s+"/?([^"]*)"\s+("[^"]*")\s*\}\}`), `{{getv "/$1" $2}}`},
+	{regexp.MustCompile(`\{\{\s*key\s+"/?([^"]*)"\s*\}\}`), `{{getv "/$1"}}`},
+	{regexp.MustCompile(`\{\{\s*keyExists\s+"/?([^"]*)"\s*\}\}`), `{{exists "/$1"}}`},
+	{regexp.MustCompile(`\{\{\s*range\s+ls\s+"/?([^"]*)"\s*\}\}`), `{{range gets "/$1/*"}}`},
+	{regexp.MustCompile(`\{\{\s*range\s+tree\s+"/?([^"]*)"\s*\}\}`), `{{range gets "/$1/*"}}`},
+	{regexp.MustCompile(`\{\{\s*env\s+"([^"]*)"\s*\}\}`), `{{getenv "$1"}}`},
+	{regexp.MustCompile(`\{\{\s*timestamp\s*\}\}`), `{{datetime.Format "2006-01-02T15:04:05Z07:00"}}`},
+}
+
+// unconvertiblePattern matches consul-template functions that have no confd
+// equivalent and need manual attention.
+var unconvertiblePattern = regexp.MustCompile(`\b(service|services|node|nodes|datacenters|secret|secrets|vault|connect|caRoots|caLeaf|safeLs|safeTree|sockaddr|byTag|byMeta)\b`)
+
+// convertConsulTemplate translates the consul-template source in data to
+// confd template syntax, writing the result to w. It returns the number of
+// constructs that could not be converted; each is reported with its line
+// number so the template can be fixed up by hand.
+func convertConsulTemplate(data []byte, w io.Writer) (int, error) {
+	unconverted := 0
+	lines := strings.Split(string(data), "\n")
+	for i, line := range lines {
+		for _, rule := range consulTemplateRules {
+			line = rule.pattern.ReplaceAllString(line, rule.replace)
+		}
+		// Anything still using a consul-template only function is left in
+		// place and reported.
+		for _, action := range regexp.MustCompile(`\{\{[^}]*\}\}`).FindAllString(line, -1) {
+			if m := unconvertiblePattern.FindString(action); m != "" {
+				log.Warning(fmt.Sprintf("line %d: cannot convert %q: %q has no confd equivalent", i+1, action, m))
+				unconverted++
+			}
+		}
+		if i > 0 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return unconverted, err
+			}
+		}
+		if _, err := io.WriteString(w, line); err != nil {
+			return unconverted, err
+		}
+	}
+	return unconverted, nil
+}
+
+// runConvert implements the "confd convert" subcommand. Only the
+// consul-template source syntax is supported.
+func runConvert(args []string, w io.Writer) (int, error) {
+	if len(args) != 2 || args[0] != "consul-template" {
+		return 0, fmt.Errorf("usage: confd convert consul-template <file>")
+	}
+	data, err := ioutil.ReadFile(args[1])
+	if err != nil {
+		return 0, err
+	}
+	return convertConsulTemplate(data, w)
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestConvertConsulTemplate(t *testing.T) {
+	in := `server {{key "app/host"}}:{{keyOrDefault "app/port" "8080"}}
+{{range ls "app/upstreams"}}upstream {{.Key}} {{.Value}}
+{{end}}{{range service "web"}}server {{.Address}}
+{{end}}`
+	var buf bytes.Buffer
+	unconverted, err := convertConsulTemplate([]byte(in), &buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unconverted != 1 {
+		t.Errorf("expected 1 unconvertible construct, got %d", unconverted)
+	}
+	want := `server {{getv "/app/host"}}:{{getv "/app/port" "8080"}}
+{{range gets "/app/upstreams/*"}}upstream {{.Key}} {{.Value}}
+{{end}}{{range service "web"}}server {{.Address}}
+{{end}}`
+	if buf.String() != want {
+		t.Errorf("unexpected conversion output.\ngot:\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/abtreece/confd/log"
+	"github.com/abtreece/confd/resource/template"
+)
+
+// splitExecArgs separates confd flags from the wrapped command at the "--"
+// marker. Without a marker everything is the command.
+func splitExecArgs(args []string) ([]string, []string) {
+	for i, arg := range args {
+		if arg == "--" {
+			return args[:i], args[i+1:]
+		}
+	}
+	return nil, args
+}
+
+// reloadSignals maps the -exec-reload-signal names to signals.
+var reloadSignals = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// execEnviron returns the confd environment extended with the variables from
+// every rendered dotenv resource, so the wrapped command starts with its
+// configuration loaded without entrypoint shell glue.
+func execEnviron() ([]string, error) {
+	env := os.Environ()
+	ts, err := template.ListResources(config.TemplateConfig)
+	if err != nil {
+		return nil, err
+	}
+	for _, info := range ts {
+		if !strings.HasSuffix(info.Dest, ".env") {
+			continue
+		}
+		data, err := ioutil.ReadFile(info.Dest)
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" || strings.HasPrefix(line, "#") || !strings.Contains(line, "=") {
+				continue
+			}
+			idx := strings.Index(line, "=")
+			key := line[:idx]
+			value := line[idx+1:]
+			if unquoted, err := strconv.Unquote(value); err == nil {
+				value = unquoted
+			}
+			env = append(env, key+"="+value)
+		}
+	}
+	return env, nil
+}
+
+// runExec renders every template resource once and then runs the given
+// command with the rendered environment. Signals are forwarded to the child.
+// With -exec-reload-signal set, resources keep being re-rendered on the
+// polling interval and the child is signalled whenever a dest changed.
+func runExec(args []string) error {
+	if len(args) == 0 {
+		return errors.New("exec: no command given after --")
+	}
+	if _, err := template.ProcessWithStatus(config.TemplateConfig); err != nil {
+		return err
+	}
+	env, err := execEnviron()
+	if err != nil {
+		return err
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Env = env
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan)
+	go func() {
+		for s := range signalChan {
+			if s == syscall.SIGCHLD {
+				continue
+			}
+			cmd.Process.Signal(s)
+		}
+	}()
+
+	if config.ExecReloadSignal != "" && !config.OneTime {
+		reloadSignal, ok := reloadSignals[strings.TrimPrefix(strings.ToUpper(config.ExecReloadSignal), "SIG")]
+		if !ok {
+			return fmt.Errorf("exec: unknown reload signal %q", config.ExecReloadSignal)
+		}
+		go func() {
+			for range time.Tick(time.Duration(config.Interval) * time.Second) {
+				updated, err := template.ProcessWithStatus(config.TemplateConfig)
+				if err != nil {
+					log.Error(err.Error())
+					continue
+				}
+				if updated {
+					log.Info("Configuration changed. Signalling " + args[0] + " with " + config.ExecReloadSignal)
+					cmd.Process.Signal(reloadSignal)
+				}
+			}
+		}()
+	}
+
+	err = cmd.Wait()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok {
+			os.Exit(status.ExitStatus())
+		}
+	}
+	return err
+}
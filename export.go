@@ -0,0 +1,64 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/abtreece/confd/backends"
+)
+
+// consulExportEntry matches the entry format produced by `consul kv export`,
+// which is also accepted by `consul kv import` and `etcdctl` based tooling.
+type consulExportEntry struct {
+	Key   string `json:"key"`
+	Flags uint64 `json:"flags"`
+	Value string `json:"value"`
+}
+
+// export reads every key under the configured prefix from the backend and
+// writes it to w in the requested format. Supported formats are json,
+// dotenv, and consul.
+func export(storeClient backends.StoreClient, prefix string, format string, w io.Writer) error {
+	if !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	values, err := storeClient.GetValues([]string{prefix})
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(values))
+	for k := range values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(values)
+	case "dotenv":
+		for _, k := range keys {
+			name := strings.ToUpper(strings.Replace(strings.Trim(k, "/"), "/", "_", -1))
+			fmt.Fprintf(w, "%s=%q\n", name, values[k])
+		}
+		return nil
+	case "consul":
+		entries := make([]consulExportEntry, 0, len(keys))
+		for _, k := range keys {
+			entries = append(entries, consulExportEntry{
+				Key:   strings.TrimPrefix(k, "/"),
+				Value: base64.StdEncoding.EncodeToString([]byte(values[k])),
+			})
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+	return fmt.Errorf("Invalid export format: %s", format)
+}
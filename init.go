@@ -0,0 +1,61 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/abtreece/confd/log"
+	util "github.com/abtreece/confd/util"
+)
+
+var initConfdToml = `backend = "%s"
+nodes = [
+%s]
+`
+
+var initResourceToml = `[template]
+src = "example.conf.tmpl"
+dest = "/tmp/example.conf"
+keys = [
+  "/example",
+]
+`
+
+var initTemplate = `# Generated by confd
+{{range gets "/example/*"}}{{base .Key}} = {{.Value}}
+{{end}}`
+
+// runInit scaffolds a working confdir: a confd.toml for the chosen backend,
+// a sample conf.d template resource, and a matching template. Existing files
+// are never overwritten.
+func runInit(confDir string, backend string, nodes []string) error {
+	for _, dir := range []string{confDir, filepath.Join(confDir, "conf.d"), filepath.Join(confDir, "templates")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	var nodeList strings.Builder
+	for _, node := range nodes {
+		fmt.Fprintf(&nodeList, "  %q,\n", node)
+	}
+	files := map[string]string{
+		filepath.Join(confDir, "confd.toml"):                     fmt.Sprintf(initConfdToml, backend, nodeList.String()),
+		filepath.Join(confDir, "conf.d", "example.toml"):         initResourceToml,
+		filepath.Join(confDir, "templates", "example.conf.tmpl"): initTemplate,
+	}
+	for path, contents := range files {
+		if util.IsFileExist(path) {
+			log.Warning("Skipping existing file " + path)
+			continue
+		}
+		if err := ioutil.WriteFile(path, []byte(contents), 0644); err != nil {
+			return err
+		}
+		log.Info("Created " + path)
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+/*
+Package metrics provides a small dependency-free metrics registry exposed in
+the Prometheus text exposition format.
+*/
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+)
+
+type value struct {
+	labels map[string]string
+	v      float64
+}
+
+type metric struct {
+	name   string
+	help   string
+	typ    string
+	values map[string]*value
+}
+
+var registry = struct {
+	sync.Mutex
+	metrics map[string]*metric
+	names   []string
+}{metrics: make(map[string]*metric)}
+
+// Register declares a metric with its help text and type ("counter" or
+// "gauge"). Metrics updated without prior registration are registered on
+// first use as untyped.
+func Register(name, help, typ string) {
+	registry.Lock()
+	defer registry.Unlock()
+	register(name, help, typ)
+}
+
+func register(name, help, typ string) *metric {
+	m, ok := registry.metrics[name]
+	if !ok {
+		m = &metric{name: name, help: help, typ: typ, values: make(map[string]*value)}
+		registry.metrics[name] = m
+		registry.names = append(registry.names, name)
+		sort.Strings(registry.names)
+	}
+	return m
+}
+
+// labelKey serializes labels into a stable map key.
+func labelKey(labels map[string]string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var b strings.Builder
+	for i, k := range keys {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, "%s=%q", k, labels[k])
+	}
+	return b.String()
+}
+
+func update(name string, labels map[string]string, f func(*value)) {
+	registry.Lock()
+	defer registry.Unlock()
+	m := register(name, "", "untyped")
+	key := labelKey(labels)
+	v, ok := m.values[key]
+	if !ok {
+		copied := make(map[string]string, len(labels))
+		for k, lv := range labels {
+			copied[k] = lv
+		}
+		v = &value{labels: copied}
+		m.values[key] = v
+	}
+	f(v)
+}
+
+// Inc increments a counter by one.
+func Inc(name string, labels map[string]string) {
+	Add(name, labels, 1)
+}
+
+// Add increments a counter by delta.
+func Add(name string, labels map[string]string, delta float64) {
+	update(name, labels, func(v *value) { v.v += delta })
+}
+
+// Set sets a gauge to v.
+func Set(name string, labels map[string]string, val float64) {
+	update(name, labels, func(v *value) { v.v = val })
+}
+
+// Dump writes all metrics whose name is accepted by allow to w in the
+// Prometheus text format.
+func Dump(w io.Writer, allow func(string) bool) {
+	collectRuntime()
+	registry.Lock()
+	defer registry.Unlock()
+	for _, name := range registry.names {
+		if allow != nil && !allow(name) {
+			continue
+		}
+		m := registry.metrics[name]
+		if m.help != "" {
+			fmt.Fprintf(w, "# HELP %s %s\n", m.name, m.help)
+		}
+		fmt.Fprintf(w, "# TYPE %s %s\n", m.name, m.typ)
+		keys := make([]string, 0, len(m.values))
+		for k := range m.values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			if k == "" {
+				fmt.Fprintf(w, "%s %g\n", m.name, m.values[k].v)
+			} else {
+				fmt.Fprintf(w, "%s{%s} %g\n", m.name, k, m.values[k].v)
+			}
+		}
+	}
+}
+
+// Handler serves the registry at /metrics. The allowlist is a list of glob
+// patterns; when non-empty only matching metric names are exposed.
+func Handler(allowlist []string) http.Handler {
+	var allow func(string) bool
+	if len(allowlist) > 0 {
+		allow = func(name string) bool {
+			for _, pattern := range allowlist {
+				if ok, _ := path.Match(pattern, name); ok {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		Dump(w, allow)
+	})
+}
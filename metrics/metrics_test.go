@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestDumpWithAllowlist(t *testing.T) {
+	Register("test_requests_total", "Test requests.", "counter")
+	Register("test_hidden_total", "Hidden.", "counter")
+	Inc("test_requests_total", map[string]string{"backend": "etcd"})
+	Inc("test_hidden_total", nil)
+
+	var buf bytes.Buffer
+	handlerAllow := func(name string) bool { return name == "test_requests_total" }
+	Dump(&buf, handlerAllow)
+	out := buf.String()
+	if !strings.Contains(out, `test_requests_total{backend="etcd"} 1`) {
+		t.Errorf("expected labeled counter in output, got:\n%s", out)
+	}
+	if strings.Contains(out, "test_hidden_total") {
+		t.Errorf("allowlist did not filter metric, got:\n%s", out)
+	}
+}
+
+func TestRuntimeCollectors(t *testing.T) {
+	SetBuildInfo(map[string]string{"version": "test"})
+	var buf bytes.Buffer
+	Dump(&buf, nil)
+	out := buf.String()
+	for _, want := range []string{"go_goroutines ", "go_memstats_alloc_bytes ", "process_start_time_seconds ", `confd_build_info{version="test"} 1`} {
+		if !strings.Contains(out, want) {
+			t.Errorf("dump is missing %q", want)
+		}
+	}
+}
@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"runtime"
+	"time"
+)
+
+func init() {
+	Register("go_goroutines", "Number of goroutines that currently exist.", "gauge")
+	Register("go_memstats_alloc_bytes", "Number of heap bytes allocated and still in use.", "gauge")
+	Register("go_memstats_sys_bytes", "Number of bytes obtained from the OS.", "gauge")
+	Register("go_memstats_heap_objects", "Number of allocated heap objects.", "gauge")
+	Register("go_gc_cycles_total", "Number of completed GC cycles.", "counter")
+	Register("process_start_time_seconds", "Start time of the process since the unix epoch in seconds.", "gauge")
+	Register("confd_build_info", "Build information; the gauge is always 1 and the labels carry version, commit, and backend.", "gauge")
+	Set("process_start_time_seconds", nil, float64(processStart.Unix()))
+}
+
+var processStart = time.Now()
+
+// collectRuntime samples the Go runtime gauges. It runs just before each
+// dump, so scrapes see current memory and goroutine figures without a
+// background sampler.
+func collectRuntime() {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+	Set("go_goroutines", nil, float64(runtime.NumGoroutine()))
+	Set("go_memstats_alloc_bytes", nil, float64(ms.Alloc))
+	Set("go_memstats_sys_bytes", nil, float64(ms.Sys))
+	Set("go_memstats_heap_objects", nil, float64(ms.HeapObjects))
+	Set("go_gc_cycles_total", nil, float64(ms.NumGC))
+}
+
+// SetBuildInfo publishes the confd_build_info gauge, so operators can alert
+// on version skew across a fleet from the existing endpoint.
+func SetBuildInfo(labels map[string]string) {
+	Set("confd_build_info", labels, 1)
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+
+	"github.com/abtreece/confd/metrics"
+	"github.com/abtreece/confd/resource/template"
+)
+
+// handleResources serves GET /api/v1/resources: a JSON summary of every
+// configured template resource.
+func handleResources(w http.ResponseWriter, r *http.Request) {
+	if config.TemplateConfig.StoreClient == nil {
+		http.Error(w, "store client not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	infos, err := template.ListResources(config.TemplateConfig)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(infos)
+}
+
+// handleResource serves GET /api/v1/resources/<dest>/rendered and
+// /api/v1/resources/<dest>/diff, where <dest> is the destination path of the
+// resource without its leading slash.
+func handleResource(w http.ResponseWriter, r *http.Request) {
+	if config.TemplateConfig.StoreClient == nil {
+		http.Error(w, "store client not initialized", http.StatusServiceUnavailable)
+		return
+	}
+	rest := strings.TrimPrefix(r.URL.Path, "/api/v1/resources/")
+	switch {
+	case strings.HasSuffix(rest, "/rendered"):
+		dest := "/" + strings.TrimSuffix(rest, "/rendered")
+		contents, err := template.RenderResource(config.TemplateConfig, dest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		w.Write(contents)
+	case strings.HasSuffix(rest, "/diff"):
+		dest := "/" + strings.TrimSuffix(rest, "/diff")
+		changed, diff, err := template.DiffResource(config.TemplateConfig, dest)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"dest":    dest,
+			"in_sync": !changed,
+			"diff":    string(diff),
+		})
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// opsAuth wraps next with the authentication configured for the operations
+// listener. A bearer token takes precedence over basic auth; with neither
+// configured requests pass through unchallenged.
+func opsAuth(next http.Handler) http.Handler {
+	if config.MetricsBearerToken != "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(config.MetricsBearerToken)) != 1 {
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	if config.MetricsAuthUser != "" {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, password, ok := r.BasicAuth()
+			userOK := subtle.ConstantTimeCompare([]byte(user), []byte(config.MetricsAuthUser)) == 1
+			passOK := subtle.ConstantTimeCompare([]byte(password), []byte(config.MetricsAuthPassword)) == 1
+			if !ok || !userOK || !passOK {
+				w.Header().Set("WWW-Authenticate", `Basic realm="confd"`)
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	return next
+}
+
+// startOpsServer serves /health and /metrics on the configured listen
+// address, optionally over TLS with client certificate verification.
+func startOpsServer() error {
+	var allowlist []string
+	if config.MetricsAllow != "" {
+		allowlist = strings.Split(config.MetricsAllow, ",")
+	}
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", opsAuth(metrics.Handler(allowlist)))
+	mux.Handle("/health", opsAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})))
+	mux.Handle("/api/v1/resources", opsAuth(http.HandlerFunc(handleResources)))
+	mux.Handle("/api/v1/errors", opsAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(template.LastErrors())
+	})))
+	mux.Handle("/api/v1/resources/", opsAuth(http.HandlerFunc(handleResource)))
+
+	server := &http.Server{Addr: config.MetricsListen, Handler: mux}
+	if config.MetricsTLSCert == "" {
+		return server.ListenAndServe()
+	}
+	if config.MetricsClientCA != "" {
+		caCert, err := ioutil.ReadFile(config.MetricsClientCA)
+		if err != nil {
+			return err
+		}
+		caCertPool := x509.NewCertPool()
+		if !caCertPool.AppendCertsFromPEM(caCert) {
+			return errors.New("no certificates found in " + config.MetricsClientCA)
+		}
+		server.TLSConfig = &tls.Config{
+			ClientCAs:  caCertPool,
+			ClientAuth: tls.RequireAndVerifyClientCert,
+		}
+	}
+	return server.ListenAndServeTLS(config.MetricsTLSCert, config.MetricsTLSKey)
+}
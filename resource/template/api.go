@@ -0,0 +1,130 @@
+package template
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"sync"
+
+	"github.com/abtreece/confd/metrics"
+)
+
+// ResourceInfo describes one template resource for the read-only API.
+type ResourceInfo struct {
+	Dest        string   `json:"dest"`
+	Src         string   `json:"src,omitempty"`
+	Prefix      string   `json:"prefix"`
+	Keys        []string `json:"keys"`
+	ContentHash string   `json:"content_hash,omitempty"`
+}
+
+// ListResources returns a summary of every configured template resource.
+func ListResources(config Config) ([]ResourceInfo, error) {
+	ts, err := getTemplateResources(config)
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]ResourceInfo, 0, len(ts))
+	for _, t := range ts {
+		infos = append(infos, ResourceInfo{
+			Dest:        t.Dest,
+			Src:         t.Src,
+			Prefix:      t.Prefix,
+			Keys:        t.Keys,
+			ContentHash: destHash(t.Dest),
+		})
+	}
+	return infos, nil
+}
+
+// destHashes tracks a short hash of the last rendered content per dest, so
+// the API and metrics can expose what each instance is actually serving.
+var destHashes = struct {
+	sync.Mutex
+	byDest map[string]string
+}{byDest: make(map[string]string)}
+
+// recordDestHash publishes the content hash of a rendered dest for the
+// resource API and the confd_dest_content_hash gauge. The gauge carries the
+// first 32 bits of the MD5 as its value, so fleets can be grouped by value
+// in Prometheus to spot instances serving divergent configs.
+func recordDestHash(dest, md5sum string, labels map[string]string) {
+	if len(md5sum) < 12 {
+		return
+	}
+	destHashes.Lock()
+	destHashes.byDest[dest] = md5sum[:12]
+	destHashes.Unlock()
+	if v, err := strconv.ParseUint(md5sum[:8], 16, 32); err == nil {
+		merged := map[string]string{"dest": dest}
+		for k, val := range labels {
+			merged[k] = val
+		}
+		metrics.Set("confd_dest_content_hash", merged, float64(v))
+	}
+}
+
+// destHash returns the recorded content hash for dest, if any.
+func destHash(dest string) string {
+	destHashes.Lock()
+	defer destHashes.Unlock()
+	return destHashes.byDest[dest]
+}
+
+// findResource returns the configured resource whose dest is dest.
+func findResource(config Config, dest string) (*TemplateResource, error) {
+	ts, err := getTemplateResources(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range ts {
+		if t.Dest == dest {
+			return t, nil
+		}
+	}
+	return nil, fmt.Errorf("no template resource with dest %s", dest)
+}
+
+// RenderResource renders the resource with the given dest from current
+// backend values and returns the content confd would write right now.
+// Values marked sensitive by secretRef are masked.
+func RenderResource(config Config, dest string) ([]byte, error) {
+	t, err := findResource(config, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := t.setFileMode(); err != nil {
+		return nil, err
+	}
+	if err := t.setVars(); err != nil {
+		return nil, err
+	}
+	if err := t.createStageFile(); err != nil {
+		return nil, err
+	}
+	staged := t.StageFile.Name()
+	defer os.Remove(staged)
+	contents, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(t.maskSecrets(string(contents))), nil
+}
+
+// DiffResource writes a line diff between the current dest file and what
+// confd would render right now. It reports whether the resource is out of
+// sync.
+func DiffResource(config Config, dest string) (bool, []byte, error) {
+	t, err := findResource(config, dest)
+	if err != nil {
+		return false, nil, err
+	}
+	var buf bytes.Buffer
+	changed, err := t.diff(&buf)
+	if err != nil {
+		return false, nil, err
+	}
+	return changed, buf.Bytes(), nil
+}
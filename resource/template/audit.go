@@ -0,0 +1,77 @@
+package template
+
+import (
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/abtreece/confd/log"
+)
+
+// auditRateLimit caps the number of audit events written per minute so a
+// flapping backend cannot fill the disk through the audit trail. Events
+// beyond the cap are dropped and counted in the next written record.
+const auditRateLimit = 120
+
+// auditEvent is one line of the append-only audit log: who changed what,
+// at which backend index, and whether the reload that followed succeeded.
+type auditEvent struct {
+	Timestamp    string            `json:"timestamp"`
+	Dest         string            `json:"dest"`
+	Labels       map[string]string `json:"labels,omitempty"`
+	OldHash      string            `json:"old_hash,omitempty"`
+	NewHash      string            `json:"new_hash,omitempty"`
+	ChangedKeys  []string          `json:"changed_keys,omitempty"`
+	BackendIndex uint64            `json:"backend_index,omitempty"`
+	ReloadResult string            `json:"reload_result,omitempty"`
+	Dropped      int               `json:"dropped_events,omitempty"`
+}
+
+// auditLog serializes writes to the audit file and tracks the rate limit
+// window and rotation threshold.
+var auditLog = struct {
+	sync.Mutex
+	windowStart time.Time
+	events      int
+	dropped     int
+}{}
+
+// writeAudit appends one event to the audit log at path as a JSON line,
+// rotating the file to path.1 when it exceeds maxBytes first.
+func writeAudit(path string, maxBytes int64, e auditEvent) {
+	auditLog.Lock()
+	defer auditLog.Unlock()
+
+	now := time.Now()
+	if now.Sub(auditLog.windowStart) >= time.Minute {
+		auditLog.windowStart = now
+		auditLog.events = 0
+	}
+	if auditLog.events >= auditRateLimit {
+		auditLog.dropped++
+		return
+	}
+	auditLog.events++
+	e.Dropped = auditLog.dropped
+	auditLog.dropped = 0
+
+	if fi, err := os.Stat(path); err == nil && maxBytes > 0 && fi.Size() >= maxBytes {
+		if err := os.Rename(path, path+".1"); err != nil {
+			log.Error("Unable to rotate audit log " + path + ": " + err.Error())
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		log.Error("Unable to open audit log " + path + ": " + err.Error())
+		return
+	}
+	defer f.Close()
+	data, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Error("Unable to write audit log " + path + ": " + err.Error())
+	}
+}
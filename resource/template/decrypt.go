@@ -0,0 +1,77 @@
+package template
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+)
+
+// Markers identifying encrypted values fetched from a backend: armored age
+// and PGP blocks, plus an explicit enc: prefix for ciphertexts stored
+// base64-encoded.
+const (
+	ageArmorHeader = "-----BEGIN AGE ENCRYPTED FILE-----"
+	pgpArmorHeader = "-----BEGIN PGP MESSAGE-----"
+	encPrefix      = "enc:"
+)
+
+// isEncrypted reports whether a backend value looks like an encrypted blob
+// the decrypt command should be run on.
+func isEncrypted(v string) bool {
+	return strings.HasPrefix(v, encPrefix) ||
+		strings.HasPrefix(v, ageArmorHeader) ||
+		strings.HasPrefix(v, pgpArmorHeader)
+}
+
+// decryptValue pipes an encrypted value through the configured decrypt
+// command (e.g. "age -d -i /etc/confd/key.txt" or "gpg -dq") and returns the
+// plaintext from its stdout. Values carrying the enc: prefix have the prefix
+// stripped and, when the remainder is base64, are decoded first so binary
+// ciphertexts survive the trip through the KV store.
+func decryptValue(command, value string) (string, error) {
+	payload := []byte(value)
+	if strings.HasPrefix(value, encPrefix) {
+		trimmed := strings.TrimPrefix(value, encPrefix)
+		payload = []byte(trimmed)
+		if decoded, err := base64.StdEncoding.DecodeString(trimmed); err == nil {
+			payload = decoded
+		}
+	}
+	var c *exec.Cmd
+	if runtime.GOOS == "windows" {
+		c = exec.Command("cmd", "/C", command)
+	} else {
+		c = exec.Command("/bin/sh", "-c", command)
+	}
+	c.Stdin = bytes.NewReader(payload)
+	var stdout, stderr bytes.Buffer
+	c.Stdout = &stdout
+	c.Stderr = &stderr
+	if err := c.Run(); err != nil {
+		return "", fmt.Errorf("decrypt command failed: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// decryptVars decrypts every encrypted value in vars in place. Plaintexts
+// are marked sensitive so diff, log, and noop output mask them.
+func (t *TemplateResource) decryptVars(vars map[string]string) error {
+	if t.decryptCmd == "" {
+		return nil
+	}
+	for k, v := range vars {
+		if !isEncrypted(v) {
+			continue
+		}
+		plain, err := decryptValue(t.decryptCmd, v)
+		if err != nil {
+			return newProcessError(ErrTemplate, t.Dest, fmt.Errorf("decrypting %s: %s", k, err))
+		}
+		vars[k] = plain
+		t.secrets[plain] = true
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package template
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	util "github.com/abtreece/confd/util"
+)
+
+// Diff renders every template resource and writes a line diff of pending
+// changes against the current dest files to w. Values marked sensitive by
+// secretRef are masked in the output. It returns the number of resources
+// that are out of sync.
+func Diff(config Config, w io.Writer) (int, error) {
+	ts, err := getTemplateResources(config)
+	if err != nil {
+		return 0, err
+	}
+	drift := 0
+	var lastErr error
+	for _, t := range ts {
+		changed, err := t.diff(w)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		if changed {
+			drift++
+		}
+	}
+	return drift, lastErr
+}
+
+// diff stages the resource and writes a diff against dest to w when the
+// rendered content differs. It reports whether the resource is out of sync.
+func (t *TemplateResource) diff(w io.Writer) (bool, error) {
+	if err := t.setFileMode(); err != nil {
+		return false, err
+	}
+	if err := t.setVars(); err != nil {
+		return false, err
+	}
+	if err := t.renderDest(); err != nil {
+		return false, err
+	}
+	if err := t.createStageFile(); err != nil {
+		return false, err
+	}
+	staged := t.StageFile.Name()
+	defer os.Remove(staged)
+
+	changed, err := util.IsConfigChanged(staged, t.Dest)
+	if err != nil {
+		return false, err
+	}
+	if !changed {
+		return false, nil
+	}
+
+	stagedContents, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return true, err
+	}
+	var destContents []byte
+	if util.IsFileExist(t.Dest) {
+		destContents, err = ioutil.ReadFile(t.Dest)
+		if err != nil {
+			return true, err
+		}
+	}
+
+	fmt.Fprintf(w, "--- %s\n", t.Dest)
+	fmt.Fprintf(w, "+++ %s (rendered from %s)\n", t.Dest, t.Src)
+	a := strings.Split(t.maskSecrets(string(destContents)), "\n")
+	b := strings.Split(t.maskSecrets(string(stagedContents)), "\n")
+	for _, line := range diffLines(a, b) {
+		fmt.Fprintln(w, line)
+	}
+	return true, nil
+}
+
+// diffLines returns a minimal line diff between a and b using a longest
+// common subsequence, with removed lines prefixed "-" and added lines
+// prefixed "+".
+func diffLines(a, b []string) []string {
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var out []string
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] == b[j]:
+			out = append(out, " "+a[i])
+			i++
+			j++
+		case lcs[i+1][j] >= lcs[i][j+1]:
+			out = append(out, "-"+a[i])
+			i++
+		default:
+			out = append(out, "+"+b[j])
+			j++
+		}
+	}
+	for ; i < len(a); i++ {
+		out = append(out, "-"+a[i])
+	}
+	for ; j < len(b); j++ {
+		out = append(out, "+"+b[j])
+	}
+	return out
+}
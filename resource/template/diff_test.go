@@ -0,0 +1,16 @@
+package template
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDiffLines(t *testing.T) {
+	a := []string{"one", "two", "three"}
+	b := []string{"one", "2", "three", "four"}
+	got := diffLines(a, b)
+	want := []string{" one", "-two", "+2", " three", "+four"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffLines() = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,91 @@
+package template
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Categories attached to errors pushed onto the processor error channel, so
+// consumers can react to the failure class instead of string-matching error
+// text.
+const (
+	ErrBackendUnreachable = "backend_unreachable"
+	ErrTemplate           = "template_error"
+	ErrCheckFailed        = "check_failed"
+	ErrReloadFailed       = "reload_failed"
+	ErrFS                 = "fs_error"
+)
+
+// ProcessError is an error from processing one template resource, carrying
+// the failure category and the resource identity.
+type ProcessError struct {
+	Category string `json:"category"`
+	Dest     string `json:"dest"`
+	Message  string `json:"message"`
+	Err      error  `json:"-"`
+}
+
+func (e *ProcessError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.Dest, e.Category, e.Message)
+}
+
+func (e *ProcessError) Unwrap() error {
+	return e.Err
+}
+
+// newProcessError wraps err with a category and resource identity. An error
+// that already carries a category is passed through unchanged, so wrapping
+// at an outer stage never masks a more specific inner category.
+func newProcessError(category, dest string, err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ProcessError); ok {
+		return err
+	}
+	return &ProcessError{Category: category, Dest: dest, Message: err.Error(), Err: err}
+}
+
+// Categorize returns the category of err, or "unknown" for errors that did
+// not pass through the processing pipeline.
+func Categorize(err error) string {
+	if pe, ok := err.(*ProcessError); ok {
+		return pe.Category
+	}
+	return "unknown"
+}
+
+// lastErrors tracks the most recent processing error per dest for the
+// readiness endpoint. A successful render clears the entry.
+var lastErrors = struct {
+	sync.Mutex
+	byDest map[string]*ProcessError
+}{byDest: make(map[string]*ProcessError)}
+
+// recordResult updates the per-dest error state after a processing run.
+func recordResult(dest string, err error) {
+	lastErrors.Lock()
+	defer lastErrors.Unlock()
+	if err == nil {
+		delete(lastErrors.byDest, dest)
+		return
+	}
+	if pe, ok := err.(*ProcessError); ok {
+		lastErrors.byDest[dest] = pe
+		return
+	}
+	lastErrors.byDest[dest] = &ProcessError{Category: "unknown", Dest: dest, Message: err.Error(), Err: err}
+}
+
+// LastErrors returns the current per-resource errors sorted by dest.
+func LastErrors() []*ProcessError {
+	lastErrors.Lock()
+	defer lastErrors.Unlock()
+	errs := make([]*ProcessError, 0, len(lastErrors.byDest))
+	for _, pe := range lastErrors.byDest {
+		errs = append(errs, pe)
+	}
+	sort.Slice(errs, func(i, j int) bool { return errs[i].Dest < errs[j].Dest })
+	return errs
+}
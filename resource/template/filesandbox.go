@@ -0,0 +1,70 @@
+package template
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	util "github.com/abtreece/confd/util"
+)
+
+// readFile returns the contents of a local file so templates can embed
+// static snippets (DH params, banner text) that do not belong in the
+// backend. Reads are restricted to the directories allowlisted with
+// file_roots and fail when no roots are configured.
+func (t *TemplateResource) readFile(name string) (string, error) {
+	resolved, err := resolveSandboxed(t.fileRoots, name)
+	if err != nil {
+		return "", err
+	}
+	b, err := ioutil.ReadFile(resolved)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// fileExists reports whether a local file exists. When file_roots is
+// configured, paths outside the allowlist report false; without it the
+// historical unrestricted behavior is kept.
+func (t *TemplateResource) fileExists(name string) bool {
+	if len(t.fileRoots) == 0 {
+		return util.IsFileExist(name)
+	}
+	resolved, err := resolveSandboxed(t.fileRoots, name)
+	if err != nil {
+		return false
+	}
+	return util.IsFileExist(resolved)
+}
+
+// resolveSandboxed resolves name to an absolute, symlink-free path and
+// verifies it sits under one of the allowlisted roots, so a symlink planted
+// inside a root cannot point reads outside it.
+func resolveSandboxed(roots []string, name string) (string, error) {
+	if len(roots) == 0 {
+		return "", errors.New("local file reads are disabled - allowlist directories with file_roots")
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	if resolved, err := filepath.EvalSymlinks(abs); err == nil {
+		abs = resolved
+	}
+	for _, root := range roots {
+		rootAbs, err := filepath.Abs(root)
+		if err != nil {
+			continue
+		}
+		if resolved, err := filepath.EvalSymlinks(rootAbs); err == nil {
+			rootAbs = resolved
+		}
+		if abs == rootAbs || strings.HasPrefix(abs, rootAbs+string(filepath.Separator)) {
+			return abs, nil
+		}
+	}
+	return "", fmt.Errorf("%s is outside the allowlisted file_roots", name)
+}
@@ -0,0 +1,33 @@
+package template
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestResolveSandboxed(t *testing.T) {
+	root, err := ioutil.TempDir("", "fileroot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	inside := filepath.Join(root, "dhparams.pem")
+	if err := ioutil.WriteFile(inside, []byte("params"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := resolveSandboxed(nil, inside); err == nil {
+		t.Error("expected an error with no roots configured")
+	}
+	if _, err := resolveSandboxed([]string{root}, inside); err != nil {
+		t.Errorf("path inside root rejected: %v", err)
+	}
+	if _, err := resolveSandboxed([]string{root}, "/etc/passwd"); err == nil {
+		t.Error("expected an error for a path outside the roots")
+	}
+	if _, err := resolveSandboxed([]string{root}, filepath.Join(root, "..", "escape")); err == nil {
+		t.Error("expected an error for a path traversing out of the root")
+	}
+}
@@ -0,0 +1,115 @@
+package template
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/abtreece/confd/backends/file"
+	util "github.com/abtreece/confd/util"
+)
+
+// RunTests renders every template resource against the fixture data in
+// testsDir and diffs the result against golden output files. Each
+// subdirectory of testsDir is one test case holding a data.yaml (or
+// data.yml/data.json) fixture and an expected/ directory with one golden
+// file per resource, named after the base name of the resource dest.
+// Results are written to w as one PASS/FAIL line per resource plus a
+// summary. It returns the number of failures.
+func RunTests(config Config, testsDir string, w io.Writer) (int, error) {
+	entries, err := ioutil.ReadDir(testsDir)
+	if err != nil {
+		return 0, err
+	}
+	cases := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			cases = append(cases, entry.Name())
+		}
+	}
+	sort.Strings(cases)
+	if len(cases) == 0 {
+		return 0, fmt.Errorf("no test cases found in %s", testsDir)
+	}
+
+	failures := 0
+	total := 0
+	for _, name := range cases {
+		caseDir := filepath.Join(testsDir, name)
+		dataFile, err := findFixture(caseDir)
+		if err != nil {
+			fmt.Fprintf(w, "FAIL %s: %s\n", name, err)
+			failures++
+			continue
+		}
+		storeClient, err := file.NewFileClient(util.Nodes{dataFile}, "*", "", "", "", "")
+		if err != nil {
+			return failures, err
+		}
+		caseConfig := config
+		caseConfig.StoreClient = storeClient
+		ts, err := getTemplateResources(caseConfig)
+		if err != nil {
+			return failures, err
+		}
+		for _, t := range ts {
+			total++
+			if err := t.runTest(caseDir, w); err != nil {
+				fmt.Fprintf(w, "FAIL %s/%s: %s\n", name, filepath.Base(t.Dest), err)
+				failures++
+			} else {
+				fmt.Fprintf(w, "PASS %s/%s\n", name, filepath.Base(t.Dest))
+			}
+		}
+	}
+	fmt.Fprintf(w, "%d resource(s) tested, %d failure(s)\n", total, failures)
+	return failures, nil
+}
+
+// findFixture locates the fixture data file for a test case directory.
+func findFixture(caseDir string) (string, error) {
+	for _, name := range []string{"data.yaml", "data.yml", "data.json"} {
+		path := filepath.Join(caseDir, name)
+		if util.IsFileExist(path) {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no data.yaml, data.yml, or data.json in %s", caseDir)
+}
+
+// runTest stages the resource and compares the rendered content against the
+// golden file for the case. It returns an error describing the mismatch.
+func (t *TemplateResource) runTest(caseDir string, w io.Writer) error {
+	golden := filepath.Join(caseDir, "expected", filepath.Base(t.Dest))
+	want, err := ioutil.ReadFile(golden)
+	if err != nil {
+		return err
+	}
+	if err := t.setVars(); err != nil {
+		return err
+	}
+	if err := t.createStageFile(); err != nil {
+		return err
+	}
+	staged := t.StageFile.Name()
+	defer os.Remove(staged)
+	got, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return err
+	}
+	if string(got) == string(want) {
+		return nil
+	}
+	fmt.Fprintf(w, "--- %s (golden)\n", golden)
+	fmt.Fprintf(w, "+++ %s (rendered from %s)\n", filepath.Base(t.Dest), t.Src)
+	a := strings.Split(string(want), "\n")
+	b := strings.Split(string(got), "\n")
+	for _, line := range diffLines(a, b) {
+		fmt.Fprintln(w, line)
+	}
+	return fmt.Errorf("rendered output does not match %s", golden)
+}
@@ -0,0 +1,232 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"strconv"
+	"strings"
+
+	util "github.com/abtreece/confd/util"
+)
+
+// applyJSONPatch treats the staged render as a patch document - RFC 7386
+// merge patch for output "merge-patch", RFC 6902 operations for output
+// "json-patch" - and replaces the stage file with the existing dest JSON
+// patched by it. The usual stage/compare/sync cycle then runs on the result,
+// so fields owned by other tools survive a render untouched.
+func (t *TemplateResource) applyJSONPatch() error {
+	patchBytes, err := ioutil.ReadFile(t.StageFile.Name())
+	if err != nil {
+		return err
+	}
+	target := []byte("{}")
+	if util.IsFileExist(t.Dest) {
+		target, err = ioutil.ReadFile(t.Dest)
+		if err != nil {
+			return err
+		}
+	}
+	var doc interface{}
+	if err := json.Unmarshal(target, &doc); err != nil {
+		return fmt.Errorf("dest %s is not valid JSON: %s", t.Dest, err)
+	}
+	switch t.Output {
+	case "merge-patch":
+		var patch interface{}
+		if err := json.Unmarshal(patchBytes, &patch); err != nil {
+			return fmt.Errorf("rendered merge patch is not valid JSON: %s", err)
+		}
+		doc = mergePatch(doc, patch)
+	case "json-patch":
+		var ops []patchOp
+		if err := json.Unmarshal(patchBytes, &ops); err != nil {
+			return fmt.Errorf("rendered patch is not a valid JSON Patch document: %s", err)
+		}
+		for i, op := range ops {
+			doc, err = op.apply(doc)
+			if err != nil {
+				return fmt.Errorf("patch operation %d (%s %s): %s", i, op.Op, op.Path, err)
+			}
+		}
+	}
+	out, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(t.StageFile.Name(), append(out, '\n'), t.FileMode)
+}
+
+// mergePatch applies an RFC 7386 JSON merge patch to target.
+func mergePatch(target, patch interface{}) interface{} {
+	patchObj, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+	targetObj, ok := target.(map[string]interface{})
+	if !ok {
+		targetObj = make(map[string]interface{})
+	}
+	for k, v := range patchObj {
+		if v == nil {
+			delete(targetObj, k)
+			continue
+		}
+		targetObj[k] = mergePatch(targetObj[k], v)
+	}
+	return targetObj
+}
+
+// patchOp is one RFC 6902 JSON Patch operation.
+type patchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from"`
+	Value interface{} `json:"value"`
+}
+
+// parsePointer splits an RFC 6901 JSON pointer into its reference tokens.
+func parsePointer(pointer string) ([]string, error) {
+	if pointer == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(pointer, "/") {
+		return nil, fmt.Errorf("invalid JSON pointer %q", pointer)
+	}
+	tokens := strings.Split(pointer[1:], "/")
+	for i, token := range tokens {
+		token = strings.Replace(token, "~1", "/", -1)
+		tokens[i] = strings.Replace(token, "~0", "~", -1)
+	}
+	return tokens, nil
+}
+
+// getPointer resolves a JSON pointer against doc.
+func getPointer(doc interface{}, pointer string) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	current := doc
+	for _, token := range tokens {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			v, ok := node[token]
+			if !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			current = v
+		case []interface{}:
+			i, err := strconv.Atoi(token)
+			if err != nil || i < 0 || i >= len(node) {
+				return nil, fmt.Errorf("invalid array index %q", token)
+			}
+			current = node[i]
+		default:
+			return nil, fmt.Errorf("path not found")
+		}
+	}
+	return current, nil
+}
+
+// setPointer sets, inserts, or removes the value at a JSON pointer and
+// returns the (possibly replaced) document root. A nil value with remove
+// true deletes the element; arrays accept "-" to append.
+func setPointer(doc interface{}, pointer string, value interface{}, remove bool) (interface{}, error) {
+	tokens, err := parsePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if len(tokens) == 0 {
+		if remove {
+			return nil, fmt.Errorf("cannot remove the document root")
+		}
+		return value, nil
+	}
+	parent, err := getPointer(doc, joinPointer(tokens[:len(tokens)-1]))
+	if err != nil {
+		return nil, err
+	}
+	last := tokens[len(tokens)-1]
+	switch node := parent.(type) {
+	case map[string]interface{}:
+		if remove {
+			if _, ok := node[last]; !ok {
+				return nil, fmt.Errorf("path not found")
+			}
+			delete(node, last)
+		} else {
+			node[last] = value
+		}
+		return doc, nil
+	case []interface{}:
+		if last == "-" && !remove {
+			node = append(node, value)
+		} else {
+			i, err := strconv.Atoi(last)
+			if err != nil || i < 0 || i > len(node) || (remove && i == len(node)) {
+				return nil, fmt.Errorf("invalid array index %q", last)
+			}
+			if remove {
+				node = append(node[:i], node[i+1:]...)
+			} else {
+				node = append(node, nil)
+				copy(node[i+1:], node[i:])
+				node[i] = value
+			}
+		}
+		return setPointer(doc, joinPointer(tokens[:len(tokens)-1]), node, false)
+	default:
+		return nil, fmt.Errorf("path not found")
+	}
+}
+
+func joinPointer(tokens []string) string {
+	if len(tokens) == 0 {
+		return ""
+	}
+	escaped := make([]string, len(tokens))
+	for i, token := range tokens {
+		token = strings.Replace(token, "~", "~0", -1)
+		escaped[i] = strings.Replace(token, "/", "~1", -1)
+	}
+	return "/" + strings.Join(escaped, "/")
+}
+
+// apply runs one patch operation against doc and returns the new root.
+func (op patchOp) apply(doc interface{}) (interface{}, error) {
+	switch op.Op {
+	case "add", "replace":
+		return setPointer(doc, op.Path, op.Value, false)
+	case "remove":
+		return setPointer(doc, op.Path, nil, true)
+	case "copy":
+		v, err := getPointer(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		return setPointer(doc, op.Path, v, false)
+	case "move":
+		v, err := getPointer(doc, op.From)
+		if err != nil {
+			return nil, err
+		}
+		doc, err = setPointer(doc, op.From, nil, true)
+		if err != nil {
+			return nil, err
+		}
+		return setPointer(doc, op.Path, v, false)
+	case "test":
+		v, err := getPointer(doc, op.Path)
+		if err != nil {
+			return nil, err
+		}
+		if !reflect.DeepEqual(v, op.Value) {
+			return nil, fmt.Errorf("test failed")
+		}
+		return doc, nil
+	default:
+		return nil, fmt.Errorf("unsupported op %q", op.Op)
+	}
+}
@@ -0,0 +1,46 @@
+package template
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMergePatch(t *testing.T) {
+	var target, patch interface{}
+	json.Unmarshal([]byte(`{"a": 1, "b": {"c": 2, "d": 3}, "e": 4}`), &target)
+	json.Unmarshal([]byte(`{"b": {"c": 9}, "e": null, "f": 5}`), &patch)
+	got := mergePatch(target, patch)
+	var want interface{}
+	json.Unmarshal([]byte(`{"a": 1, "b": {"c": 9, "d": 3}, "f": 5}`), &want)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergePatch = %v, want %v", got, want)
+	}
+}
+
+func TestJSONPatchOps(t *testing.T) {
+	var doc interface{}
+	json.Unmarshal([]byte(`{"a": {"b": [1, 2]}, "c": 3}`), &doc)
+	var ops []patchOp
+	err := json.Unmarshal([]byte(`[
+		{"op": "test", "path": "/c", "value": 3},
+		{"op": "add", "path": "/a/b/-", "value": 4},
+		{"op": "remove", "path": "/a/b/0"},
+		{"op": "replace", "path": "/c", "value": "x"},
+		{"op": "move", "from": "/c", "path": "/d"}
+	]`), &ops)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, op := range ops {
+		doc, err = op.apply(doc)
+		if err != nil {
+			t.Fatalf("op %d: %v", i, err)
+		}
+	}
+	var want interface{}
+	json.Unmarshal([]byte(`{"a": {"b": [2, 4]}, "d": "x"}`), &want)
+	if !reflect.DeepEqual(doc, want) {
+		t.Errorf("patched doc = %v, want %v", doc, want)
+	}
+}
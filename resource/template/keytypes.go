@@ -0,0 +1,83 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// checkKeyTypes asserts the declared type or pattern of each key in
+// key_types against the fetched values, so malformed backend data fails the
+// render with a clear error instead of being templated into production
+// configs. Declared keys that were not fetched are skipped; strict mode is
+// the tool for asserting presence.
+func checkKeyTypes(keyTypes map[string]string, vars map[string]string) error {
+	var failures []string
+	for key, want := range keyTypes {
+		value, ok := vars[key]
+		if !ok {
+			continue
+		}
+		if err := checkValueType(value, want); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %s", key, err))
+		}
+	}
+	if len(failures) == 0 {
+		return nil
+	}
+	sort.Strings(failures)
+	return fmt.Errorf("backend values failed type assertions: %s", strings.Join(failures, "; "))
+}
+
+// checkValueType asserts one value against a declared type ("string",
+// "int", "float", "bool", "json", "json-array", "json-object") or a
+// "regex:<pattern>" assertion.
+func checkValueType(value, want string) error {
+	if strings.HasPrefix(want, "regex:") {
+		pattern := strings.TrimPrefix(want, "regex:")
+		matched, err := regexp.MatchString(pattern, value)
+		if err != nil {
+			return fmt.Errorf("invalid pattern %q: %s", pattern, err)
+		}
+		if !matched {
+			return fmt.Errorf("value %q does not match %q", value, pattern)
+		}
+		return nil
+	}
+	switch want {
+	case "string":
+		return nil
+	case "int":
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("value %q is not a valid int", value)
+		}
+	case "float":
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("value %q is not a valid float", value)
+		}
+	case "bool":
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("value %q is not a valid bool", value)
+		}
+	case "json":
+		if !json.Valid([]byte(value)) {
+			return fmt.Errorf("value is not valid JSON")
+		}
+	case "json-array":
+		var v []interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return fmt.Errorf("value is not a JSON array")
+		}
+	case "json-object":
+		var v map[string]interface{}
+		if err := json.Unmarshal([]byte(value), &v); err != nil {
+			return fmt.Errorf("value is not a JSON object")
+		}
+	default:
+		return fmt.Errorf("unknown type assertion %q", want)
+	}
+	return nil
+}
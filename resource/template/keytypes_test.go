@@ -0,0 +1,34 @@
+package template
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestCheckKeyTypes(t *testing.T) {
+	vars := map[string]string{
+		"/app/port":  "8080",
+		"/app/debug": "true",
+		"/app/hosts": `["a", "b"]`,
+		"/app/name":  "web-1",
+	}
+	keyTypes := map[string]string{
+		"/app/port":    "int",
+		"/app/debug":   "bool",
+		"/app/hosts":   "json-array",
+		"/app/name":    "regex:^web-[0-9]+$",
+		"/app/missing": "int",
+	}
+	if err := checkKeyTypes(keyTypes, vars); err != nil {
+		t.Errorf("checkKeyTypes: %v", err)
+	}
+
+	vars["/app/port"] = "not-a-port"
+	err := checkKeyTypes(keyTypes, vars)
+	if err == nil {
+		t.Fatal("expected an error for a non-integer port")
+	}
+	if !strings.Contains(err.Error(), "/app/port") {
+		t.Errorf("error does not name the failing key: %v", err)
+	}
+}
@@ -0,0 +1,195 @@
+package template
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/abtreece/confd/log"
+	util "github.com/abtreece/confd/util"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// kubeServiceAccountDir is where in-cluster credentials are mounted.
+const kubeServiceAccountDir = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// kubeClient talks to a Kubernetes API server using the in-cluster service
+// account. Rendered manifests are sent as server-side apply patches, so the
+// API server owns the merge and confd shows up as the field manager.
+type kubeClient struct {
+	server string
+	token  string
+	client *http.Client
+}
+
+// newKubeClient builds a client from the in-cluster environment: the
+// KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT variables and the
+// mounted service account token and CA bundle.
+func newKubeClient() (*kubeClient, error) {
+	host := os.Getenv("KUBERNETES_SERVICE_HOST")
+	port := os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, fmt.Errorf("not running in cluster: KUBERNETES_SERVICE_HOST and KUBERNETES_SERVICE_PORT must be set")
+	}
+	token, err := ioutil.ReadFile(kubeServiceAccountDir + "/token")
+	if err != nil {
+		return nil, fmt.Errorf("unable to read service account token: %s", err)
+	}
+	tlsConfig := &tls.Config{}
+	if caCert, err := ioutil.ReadFile(kubeServiceAccountDir + "/ca.crt"); err == nil {
+		pool := x509.NewCertPool()
+		pool.AppendCertsFromPEM(caCert)
+		tlsConfig.RootCAs = pool
+	}
+	return &kubeClient{
+		server: "https://" + net.JoinHostPort(host, port),
+		token:  strings.TrimSpace(string(token)),
+		client: &http.Client{
+			Timeout:   30 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		},
+	}, nil
+}
+
+// kubeObject is the subset of a manifest needed to address it in the API.
+type kubeObject struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Metadata   struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+// pluralize maps a manifest kind to its lowercase resource name the way the
+// API server does for built-in types.
+func pluralize(kind string) string {
+	kind = strings.ToLower(kind)
+	switch {
+	case strings.HasSuffix(kind, "s"), strings.HasSuffix(kind, "x"), strings.HasSuffix(kind, "z"):
+		return kind + "es"
+	case strings.HasSuffix(kind, "y"):
+		return strings.TrimSuffix(kind, "y") + "ies"
+	default:
+		return kind + "s"
+	}
+}
+
+// resourcePath returns the REST path for obj. Core group objects live under
+// /api/v1; everything else under /apis/<group>/<version>.
+func resourcePath(obj kubeObject) (string, error) {
+	if obj.APIVersion == "" || obj.Kind == "" || obj.Metadata.Name == "" {
+		return "", fmt.Errorf("manifest is missing apiVersion, kind, or metadata.name")
+	}
+	base := "/apis/" + obj.APIVersion
+	if !strings.Contains(obj.APIVersion, "/") {
+		base = "/api/" + obj.APIVersion
+	}
+	namespace := obj.Metadata.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+	return fmt.Sprintf("%s/namespaces/%s/%s/%s", base, namespace, pluralize(obj.Kind), obj.Metadata.Name), nil
+}
+
+// apply sends one manifest document as a server-side apply patch with field
+// manager "confd".
+func (c *kubeClient) apply(doc []byte) error {
+	var obj kubeObject
+	if err := yaml.Unmarshal(doc, &obj); err != nil {
+		return fmt.Errorf("invalid manifest: %s", err)
+	}
+	path, err := resourcePath(obj)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("PATCH", c.server+path+"?fieldManager=confd&force=true", bytes.NewReader(doc))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/apply-patch+yaml")
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("apply of %s/%s failed: %s: %s", obj.Kind, obj.Metadata.Name, resp.Status, strings.TrimSpace(string(body)))
+	}
+	log.Info("Applied " + obj.Kind + " " + obj.Metadata.Name + " (" + resp.Status + ")")
+	return nil
+}
+
+// splitManifestDocs splits a rendered multi-document YAML stream into its
+// individual documents, dropping empty ones.
+func splitManifestDocs(data []byte) [][]byte {
+	docs := [][]byte{}
+	for _, doc := range strings.Split(string(data), "\n---") {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		docs = append(docs, []byte(doc))
+	}
+	return docs
+}
+
+// applyStageFile sends every document in the staged render to the API server
+// instead of syncing it to dest. In noop mode the objects that would be
+// applied are only logged.
+func (t *TemplateResource) applyStageFile() error {
+	staged := t.StageFile.Name()
+	if t.keepStageFile {
+		log.Info("Keeping staged file: " + staged)
+	} else {
+		defer os.Remove(staged)
+	}
+	data, err := ioutil.ReadFile(staged)
+	if err != nil {
+		return err
+	}
+	docs := splitManifestDocs(data)
+	if t.noop {
+		for _, doc := range docs {
+			var obj kubeObject
+			if err := yaml.Unmarshal(doc, &obj); err != nil {
+				continue
+			}
+			log.Warning("Noop mode enabled. " + obj.Kind + " " + obj.Metadata.Name + " will not be applied")
+		}
+		return nil
+	}
+
+	// Skip the API round-trips entirely when the render is byte-identical to
+	// the last one that was applied.
+	if ok, err := util.IsConfigChanged(staged, t.Dest); err == nil && !ok {
+		log.Debug("Rendered manifests for " + t.Dest + " unchanged. Skipping apply")
+		return nil
+	}
+
+	client, err := newKubeClient()
+	if err != nil {
+		return err
+	}
+	for _, doc := range docs {
+		if err := client.apply(doc); err != nil {
+			return err
+		}
+	}
+
+	// Keep a local copy at dest so the next run can detect an unchanged
+	// render without talking to the API server.
+	if err := os.Rename(staged, t.Dest); err != nil {
+		return err
+	}
+	t.updated = true
+	return nil
+}
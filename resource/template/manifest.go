@@ -0,0 +1,107 @@
+package template
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/abtreece/confd/log"
+	util "github.com/abtreece/confd/util"
+)
+
+// manifest is the on-disk record of every dest confd manages, so files left
+// behind by renamed or removed resources can be identified across runs.
+type manifest struct {
+	Dests []string `json:"dests"`
+}
+
+// readManifest loads the manifest at path. A missing file is an empty
+// manifest.
+func readManifest(path string) ([]string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var m manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		return nil, err
+	}
+	return m.Dests, nil
+}
+
+// writeManifest atomically replaces the manifest at path.
+func writeManifest(path string, dests []string) error {
+	sort.Strings(dests)
+	data, err := json.MarshalIndent(manifest{Dests: dests}, "", "  ")
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := temp.Write(data); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	temp.Close()
+	return os.Rename(temp.Name(), path)
+}
+
+// syncManifest reconciles the manifest with the currently defined resources.
+// With cleanup enabled, previously generated files that no resource defines
+// anymore are deleted; in noop mode the pending removals are only logged and
+// the manifest keeps its entries so a real run can still act on them.
+func syncManifest(config Config, ts []*TemplateResource) {
+	current := make([]string, 0, len(ts))
+	managed := make(map[string]bool, len(ts))
+	for _, t := range ts {
+		current = append(current, t.Dest)
+		managed[t.Dest] = true
+	}
+
+	previous, err := readManifest(config.ManifestFile)
+	if err != nil {
+		log.Error("Unable to read manifest " + config.ManifestFile + ": " + err.Error())
+		return
+	}
+
+	removed := true
+	if config.Cleanup {
+		for _, dest := range previous {
+			if managed[dest] || !util.IsFileExist(dest) {
+				continue
+			}
+			if config.Noop {
+				log.Info("Noop mode enabled. " + dest + " is no longer managed and would be removed")
+				removed = false
+				continue
+			}
+			if err := os.Remove(dest); err != nil {
+				log.Error("Unable to remove unmanaged file " + dest + ": " + err.Error())
+				removed = false
+				continue
+			}
+			log.Info("Removed unmanaged file " + dest)
+		}
+	}
+
+	// Without cleanup (or while a removal is pending) old entries are kept
+	// so a later cleanup run still sees them.
+	if !config.Cleanup || !removed {
+		for _, dest := range previous {
+			if !managed[dest] {
+				current = append(current, dest)
+			}
+		}
+	}
+	if err := writeManifest(config.ManifestFile, current); err != nil {
+		log.Error("Unable to write manifest " + config.ManifestFile + ": " + err.Error())
+	}
+}
@@ -0,0 +1,56 @@
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/abtreece/confd/log"
+)
+
+// renderEvent is the JSON payload POSTed to the notify webhook after each
+// render, for external change-audit pipelines.
+type renderEvent struct {
+	Dest       string `json:"dest"`
+	Status     string `json:"status"`
+	DurationMs int64  `json:"duration_ms"`
+	Error      string `json:"error,omitempty"`
+}
+
+var notifyClient = &http.Client{Timeout: 5 * time.Second}
+
+// notifyRender reports the outcome of a render to the configured webhook.
+// Delivery failures are logged but never fail the render itself.
+func (t *TemplateResource) notifyRender(duration time.Duration, err error) {
+	if t.notifyWebhook == "" {
+		return
+	}
+	event := renderEvent{
+		Dest:       t.Dest,
+		DurationMs: duration.Milliseconds(),
+	}
+	switch {
+	case err != nil:
+		event.Status = "error"
+		event.Error = t.maskSecrets(err.Error())
+	case t.updated:
+		event.Status = "updated"
+	default:
+		event.Status = "unchanged"
+	}
+	payload, merr := json.Marshal(event)
+	if merr != nil {
+		log.Error("Cannot marshal render event: " + merr.Error())
+		return
+	}
+	resp, perr := notifyClient.Post(t.notifyWebhook, "application/json", bytes.NewReader(payload))
+	if perr != nil {
+		log.Warning("Cannot deliver render event for " + t.Dest + ": " + perr.Error())
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 400 {
+		log.Warning("Render event webhook for " + t.Dest + " returned " + resp.Status)
+	}
+}
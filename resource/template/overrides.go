@@ -0,0 +1,46 @@
+package template
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// loadOverrides reads a local override file and returns its contents
+// flattened into absolute key paths, the same shape setVars produces from
+// backend values. Overrides are applied over backend results with the
+// highest precedence, giving operators a break-glass way to pin a value
+// when the central backend has bad data.
+func loadOverrides(file string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var doc interface{}
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, fmt.Errorf("invalid overrides file %s: %s", file, err)
+	}
+	vars := make(map[string]string)
+	flattenOverride("/", doc, vars)
+	return vars, nil
+}
+
+// flattenOverride walks nested maps and lists, joining map keys and list
+// indexes into key paths.
+func flattenOverride(key string, value interface{}, vars map[string]string) {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		for childKey, childValue := range v {
+			flattenOverride(path.Join(key, fmt.Sprintf("%v", childKey)), childValue, vars)
+		}
+	case []interface{}:
+		for i, item := range v {
+			flattenOverride(path.Join(key, fmt.Sprintf("%d", i)), item, vars)
+		}
+	case nil:
+	default:
+		vars[key] = fmt.Sprintf("%v", v)
+	}
+}
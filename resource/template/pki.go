@@ -0,0 +1,181 @@
+package template
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/abtreece/confd/backends"
+	"github.com/abtreece/confd/log"
+	"github.com/abtreece/confd/metrics"
+)
+
+func init() {
+	metrics.Register("confd_cert_renewals_total", "Number of certificates issued or renewed through the backend PKI engine.", "counter")
+}
+
+// PKIConfig declares a certificate a resource wants issued by the Vault PKI
+// secrets engine. The certificate, private key, and issuing chain are
+// written next to the regular dest files and renewed at two thirds of the
+// certificate lifetime, replacing a separate cert renewal daemon.
+type PKIConfig struct {
+	Mount      string   `toml:"mount" yaml:"mount" json:"mount"`
+	Role       string   `toml:"role" yaml:"role" json:"role"`
+	CommonName string   `toml:"common_name" yaml:"common_name" json:"common_name"`
+	TTL        string   `toml:"ttl" yaml:"ttl" json:"ttl"`
+	Sans       []string `toml:"sans" yaml:"sans" json:"sans"`
+	CertFile   string   `toml:"cert_file" yaml:"cert_file" json:"cert_file"`
+	KeyFile    string   `toml:"key_file" yaml:"key_file" json:"key_file"`
+	CAFile     string   `toml:"ca_file" yaml:"ca_file" json:"ca_file"`
+}
+
+// validate fills in defaults and rejects declarations missing the fields
+// the issue request and file writes need.
+func (p *PKIConfig) validate() error {
+	if p.Role == "" || p.CommonName == "" {
+		return errors.New("pki requires role and common_name")
+	}
+	if p.CertFile == "" || p.KeyFile == "" {
+		return errors.New("pki requires cert_file and key_file")
+	}
+	if p.Mount == "" {
+		p.Mount = "pki"
+	}
+	return nil
+}
+
+// renewDue reports whether the certificate at cert_file is missing,
+// unparseable, or past two thirds of its lifetime.
+func (p *PKIConfig) renewDue(now time.Time) bool {
+	pemBytes, err := ioutil.ReadFile(p.CertFile)
+	if err != nil {
+		return true
+	}
+	block, _ := pem.Decode(pemBytes)
+	if block == nil {
+		return true
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return true
+	}
+	renewAt := cert.NotBefore.Add(cert.NotAfter.Sub(cert.NotBefore) * 2 / 3)
+	return !now.Before(renewAt)
+}
+
+// processPKI issues or renews the declared certificate and runs reload_cmd
+// when new material was written. It is a no-op while the current
+// certificate is still within two thirds of its lifetime.
+func (t *TemplateResource) processPKI() error {
+	renewed, err := t.renewCert()
+	if err != nil {
+		return err
+	}
+	if !renewed {
+		return nil
+	}
+	t.updated = true
+	if !t.syncOnly && t.ReloadCmd != "" {
+		log.Debug("Running " + t.ReloadCmd)
+		if err := t.reload(); err != nil {
+			return newProcessError(ErrReloadFailed, t.Dest, err)
+		}
+	}
+	return nil
+}
+
+// renewCert asks the backend PKI engine for a certificate when renewal is
+// due and writes cert, key, and chain into place. It reports whether new
+// material was written.
+func (t *TemplateResource) renewCert() (bool, error) {
+	if !t.PKI.renewDue(time.Now()) {
+		return false, nil
+	}
+	if t.noop {
+		log.Warning("Noop mode enabled. Certificate renewal for " + t.PKI.CertFile + " skipped")
+		return false, nil
+	}
+	data := map[string]interface{}{
+		"common_name": t.PKI.CommonName,
+	}
+	if t.PKI.TTL != "" {
+		data["ttl"] = t.PKI.TTL
+	}
+	if len(t.PKI.Sans) > 0 {
+		data["alt_names"] = strings.Join(t.PKI.Sans, ",")
+	}
+	secret, err := backends.IssueCert(t.storeClient, t.PKI.Mount+"/issue/"+t.PKI.Role, data)
+	if err != nil {
+		return false, newProcessError(ErrBackendUnreachable, t.Dest, err)
+	}
+	if err := t.PKI.writeFiles(secret); err != nil {
+		return false, newProcessError(ErrFS, t.Dest, err)
+	}
+	log.Info("Issued certificate for " + t.PKI.CommonName + " to " + t.PKI.CertFile)
+	metrics.Inc("confd_cert_renewals_total", t.metricLabels(map[string]string{"common_name": t.PKI.CommonName}))
+	return true, nil
+}
+
+// writeFiles writes the issue response to cert_file, key_file, and ca_file.
+func (p *PKIConfig) writeFiles(data map[string]interface{}) error {
+	cert, _ := data["certificate"].(string)
+	key, _ := data["private_key"].(string)
+	if cert == "" || key == "" {
+		return errors.New("issue response is missing certificate or private_key")
+	}
+	if err := writePEM(p.CertFile, cert, 0644); err != nil {
+		return err
+	}
+	if err := writePEM(p.KeyFile, key, 0600); err != nil {
+		return err
+	}
+	if chain := certChain(data); p.CAFile != "" && chain != "" {
+		if err := writePEM(p.CAFile, chain, 0644); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// certChain assembles the issuing chain from an issue response, preferring
+// the full ca_chain over the single issuing_ca.
+func certChain(data map[string]interface{}) string {
+	if chain, ok := data["ca_chain"].([]interface{}); ok && len(chain) > 0 {
+		parts := make([]string, 0, len(chain))
+		for _, c := range chain {
+			if s, ok := c.(string); ok {
+				parts = append(parts, strings.TrimSpace(s))
+			}
+		}
+		return strings.Join(parts, "\n")
+	}
+	ca, _ := data["issuing_ca"].(string)
+	return ca
+}
+
+// writePEM writes material to a temp file beside dest and renames it into
+// place, so readers never observe a partially written certificate or key.
+func writePEM(dest, material string, mode os.FileMode) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(dest), "."+filepath.Base(dest))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.WriteString(strings.TrimSpace(material) + "\n"); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
@@ -2,13 +2,25 @@ package template
 
 import (
 	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/abtreece/confd/log"
+	"github.com/abtreece/confd/metrics"
 	util "github.com/abtreece/confd/util"
 )
 
+func init() {
+	metrics.Register("confd_watch_restarts_total", "Number of stalled watch goroutines restarted by the watchdog.", "counter")
+	metrics.Register("confd_batch_flushes_total", "Debounced flushes per batch group.", "counter")
+	metrics.Register("confd_batch_flush_size", "Number of resources rendered by the last flush of each batch group.", "gauge")
+}
+
 type Processor interface {
 	Process()
 }
@@ -21,10 +33,32 @@ func Process(config Config) error {
 	return process(ts)
 }
 
+// ProcessWithStatus renders every template resource once like Process and
+// additionally reports whether any dest file was updated.
+func ProcessWithStatus(config Config) (bool, error) {
+	ts, err := getTemplateResources(config)
+	if err != nil {
+		return false, err
+	}
+	err = process(ts)
+	updated := false
+	for _, t := range ts {
+		if t.updated {
+			updated = true
+		}
+	}
+	return updated, err
+}
+
 func process(ts []*TemplateResource) error {
 	var lastErr error
 	for _, t := range ts {
-		if err := t.process(); err != nil {
+		start := time.Now()
+		err := t.process()
+		t.notifyRender(time.Since(start), err)
+		recordResult(t.Dest, err)
+		if err != nil {
+			metrics.Inc("confd_process_errors_total", t.metricLabels(map[string]string{"category": Categorize(err), "dest": t.Dest}))
 			log.Error(err.Error())
 			lastErr = err
 		}
@@ -32,47 +66,164 @@ func process(ts []*TemplateResource) error {
 	return lastErr
 }
 
+// ExitMaxFailures is the exit code used when the interval processor gives
+// up after the configured number of consecutive failed runs, so supervisors
+// can tell a dead backend from an ordinary error.
+const ExitMaxFailures = 6
+
 type intervalProcessor struct {
 	config   Config
 	stopChan chan bool
 	doneChan chan bool
 	errChan  chan error
-	interval int
 }
 
-func IntervalProcessor(config Config, stopChan, doneChan chan bool, errChan chan error, interval int) Processor {
-	return &intervalProcessor{config, stopChan, doneChan, errChan, interval}
+func IntervalProcessor(config Config, stopChan, doneChan chan bool, errChan chan error) Processor {
+	return &intervalProcessor{config, stopChan, doneChan, errChan}
+}
+
+// splayDelay returns a random duration within the configured splay window,
+// spreading ticks across instances so fleets sharing a backend do not poll
+// in lockstep.
+func (p *intervalProcessor) splayDelay() time.Duration {
+	if p.config.Splay <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(p.config.Splay))) * time.Second
 }
 
 func (p *intervalProcessor) Process() {
 	defer close(p.doneChan)
+	if p.config.IntervalMin > 0 && p.config.IntervalMax >= p.config.IntervalMin {
+		p.processAdaptive()
+		return
+	}
+	// Offset the first run so restarts across a fleet do not line up.
+	if delay := p.splayDelay(); delay > 0 {
+		log.Debug(fmt.Sprintf("Splaying first run by %s", delay))
+		select {
+		case <-p.stopChan:
+			return
+		case <-time.After(delay):
+		}
+	}
+	failures := 0
 	for {
 		ts, err := getTemplateResources(p.config)
 		if err != nil {
 			log.Fatal(err.Error())
 			break
 		}
-		process(ts)
+		if err := process(ts); err != nil {
+			failures++
+			if p.config.MaxFailures > 0 && failures >= p.config.MaxFailures {
+				log.Error(fmt.Sprintf("Giving up after %d consecutive failed runs: %s", failures, err.Error()))
+				os.Exit(ExitMaxFailures)
+			}
+		} else {
+			if failures > 0 {
+				log.Info(fmt.Sprintf("Recovered after %d consecutive failed runs", failures))
+			}
+			failures = 0
+		}
+		delay := time.Duration(p.config.Interval)*time.Second + p.splayDelay()
+		if failures > 0 && p.config.IntervalBackoffMax > 0 {
+			delay = p.failureBackoff(failures)
+			log.Warning(fmt.Sprintf("Backing off for %s after %d consecutive failed runs", delay, failures))
+		}
 		select {
 		case <-p.stopChan:
-			break
-		case <-time.After(time.Duration(p.interval) * time.Second):
+			return
+		case <-time.After(delay):
 			continue
 		}
 	}
 }
 
+// failureBackoff returns the delay before the next run after the given
+// number of consecutive failures: the interval doubled per failure, capped
+// at the configured maximum, with 10% jitter so a fleet sharing a dead
+// backend does not hammer it in lockstep when it comes back.
+func (p *intervalProcessor) failureBackoff(failures int) time.Duration {
+	delay := time.Duration(p.config.Interval) * time.Second << uint(failures-1)
+	max := time.Duration(p.config.IntervalBackoffMax) * time.Second
+	if delay > max || delay <= 0 {
+		delay = max
+	}
+	jitter := time.Duration((rand.Float64()*2 - 1) * 0.1 * float64(delay))
+	return delay + jitter
+}
+
+// processAdaptive polls each resource in its own loop with an interval that
+// adapts to change frequency: halved after a render that updated dest, down
+// to the floor, and doubled after a quiet poll, up to the ceiling. Busy
+// resources stay responsive during deploys while quiet ones back off and
+// stop burning backend request quota.
+func (p *intervalProcessor) processAdaptive() {
+	ts, err := getTemplateResources(p.config)
+	if err != nil {
+		log.Fatal(err.Error())
+		return
+	}
+	floor := time.Duration(p.config.IntervalMin) * time.Second
+	ceiling := time.Duration(p.config.IntervalMax) * time.Second
+	var wg sync.WaitGroup
+	for _, t := range ts {
+		wg.Add(1)
+		go func(t *TemplateResource) {
+			defer wg.Done()
+			current := time.Duration(p.config.Interval) * time.Second
+			if current < floor {
+				current = floor
+			}
+			if current > ceiling {
+				current = ceiling
+			}
+			for {
+				start := time.Now()
+				err := t.process()
+				t.notifyRender(time.Since(start), err)
+				recordResult(t.Dest, err)
+				if err != nil {
+					metrics.Inc("confd_process_errors_total", t.metricLabels(map[string]string{"category": Categorize(err), "dest": t.Dest}))
+					p.errChan <- err
+				}
+				if t.updated {
+					current /= 2
+					if current < floor {
+						current = floor
+					}
+				} else {
+					current *= 2
+					if current > ceiling {
+						current = ceiling
+					}
+				}
+				log.Debug(fmt.Sprintf("Next poll for %s in %s", t.Dest, current))
+				select {
+				case <-p.stopChan:
+					return
+				case <-time.After(current + p.splayDelay()):
+				}
+			}
+		}(t)
+	}
+	wg.Wait()
+}
+
 type watchProcessor struct {
 	config   Config
 	stopChan chan bool
 	doneChan chan bool
 	errChan  chan error
 	wg       sync.WaitGroup
+	// groups indexes resources by batch_group so members flush together.
+	groups map[string][]*TemplateResource
 }
 
 func WatchProcessor(config Config, stopChan, doneChan chan bool, errChan chan error) Processor {
 	var wg sync.WaitGroup
-	return &watchProcessor{config, stopChan, doneChan, errChan, wg}
+	return &watchProcessor{config, stopChan, doneChan, errChan, wg, nil}
 }
 
 func (p *watchProcessor) Process() {
@@ -82,19 +233,236 @@ func (p *watchProcessor) Process() {
 		log.Fatal(err.Error())
 		return
 	}
+	p.groups = make(map[string][]*TemplateResource)
+	for _, t := range ts {
+		if t.BatchGroup != "" {
+			p.groups[t.BatchGroup] = append(p.groups[t.BatchGroup], t)
+		}
+	}
+	monitors := make([]*watchMonitor, 0, len(ts))
+	for _, t := range ts {
+		monitors = append(monitors, p.startMonitor(t))
+	}
+	if p.config.LocalOverrides != "" {
+		p.wg.Add(1)
+		go p.monitorOverrides(ts)
+	}
+	if p.config.WatchWatchdog > 0 {
+		p.wg.Add(1)
+		go p.watchdog(monitors)
+	}
+	if p.config.RecheckInterval > 0 {
+		p.wg.Add(1)
+		go p.recheckDrift(ts)
+	}
+	for _, t := range ts {
+		if t.PKI != nil {
+			p.wg.Add(1)
+			go p.renewCerts(t)
+		}
+	}
 	for _, t := range ts {
-		t := t
 		p.wg.Add(1)
-		go p.monitorPrefix(t)
+		go p.watchExpiry(t)
 	}
 	p.wg.Wait()
 }
 
-func (p *watchProcessor) monitorPrefix(t *TemplateResource) {
+// renewCerts re-checks a PKI resource's certificate lifetime once a minute
+// and processes the resource again when renewal is due, so watch mode
+// renews certificates even when no backend key changes.
+func (p *watchProcessor) renewCerts(t *TemplateResource) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			if t.PKI.renewDue(time.Now()) {
+				process([]*TemplateResource{t})
+			}
+		}
+	}
+}
+
+// watchExpiry re-processes a resource shortly after the earliest lease or
+// TTL expiry recorded on its last fetch, so values that vanish on expiry
+// (an etcd lease lapsing, a session-bound key) are re-rendered even when the
+// watch never reports a change event for them.
+func (p *watchProcessor) watchExpiry(t *TemplateResource) {
+	defer p.wg.Done()
+	for {
+		wait := 30 * time.Second
+		if expiry := t.nextKeyExpiry(); !expiry.IsZero() {
+			// Fire a moment after expiry so the backend has already
+			// dropped the value by the time we re-fetch.
+			if until := time.Until(expiry) + time.Second; until < wait {
+				wait = until
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-p.stopChan:
+			timer.Stop()
+			return
+		case <-timer.C:
+			if expiry := t.nextKeyExpiry(); !expiry.IsZero() && !time.Now().Before(expiry) {
+				log.Debug("Lease expiry passed for " + t.Dest + ". Re-rendering")
+				process([]*TemplateResource{t})
+			}
+		}
+	}
+}
+
+// recheckDrift periodically verifies every dest file against the state confd
+// last rendered - content hash, mode, and owner - and re-renders resources
+// that other tooling clobbered, even when no backend change arrives to
+// trigger a watch.
+func (p *watchProcessor) recheckDrift(ts []*TemplateResource) {
+	defer p.wg.Done()
+	ticker := time.NewTicker(time.Duration(p.config.RecheckInterval) * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			for _, t := range ts {
+				if !t.drifted() {
+					continue
+				}
+				log.Warning("Target config " + t.Dest + " drifted from last rendered state. Repairing...")
+				metrics.Inc("confd_drift_repairs_total", t.metricLabels(map[string]string{"dest": t.Dest}))
+				process([]*TemplateResource{t})
+			}
+		}
+	}
+}
+
+// watchMonitor tracks the liveness of one watch goroutine so the watchdog
+// can detect and restart wedged watches. It carries its own stop channel,
+// which the forwarder goroutine in startMonitor ties to the processor-wide
+// one.
+type watchMonitor struct {
+	t            *TemplateResource
+	stopChan     chan bool
+	lastActivity int64
+	mu           sync.Mutex
+	stopped      bool
+}
+
+// touch records activity on the watch.
+func (m *watchMonitor) touch() {
+	atomic.StoreInt64(&m.lastActivity, time.Now().UnixNano())
+}
+
+// idle returns how long the watch has been without activity.
+func (m *watchMonitor) idle() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&m.lastActivity)))
+}
+
+// stop closes the monitor's stop channel exactly once.
+func (m *watchMonitor) stop() {
+	m.mu.Lock()
+	if !m.stopped {
+		m.stopped = true
+		close(m.stopChan)
+	}
+	m.mu.Unlock()
+}
+
+// startMonitor launches the watch goroutine for t plus a forwarder that
+// propagates processor shutdown to the monitor.
+func (p *watchProcessor) startMonitor(t *TemplateResource) *watchMonitor {
+	m := &watchMonitor{t: t, stopChan: make(chan bool)}
+	m.touch()
+	go func() {
+		select {
+		case <-p.stopChan:
+			m.stop()
+		case <-m.stopChan:
+		}
+	}()
+	p.wg.Add(1)
+	go p.monitorPrefix(m)
+	return m
+}
+
+// watchdog periodically checks every watch for staleness. A watch that has
+// produced no activity for the configured period while the backend still
+// answers reads is considered wedged and is torn down and recreated.
+func (p *watchProcessor) watchdog(monitors []*watchMonitor) {
 	defer p.wg.Done()
+	period := time.Duration(p.config.WatchWatchdog) * time.Second
+	ticker := time.NewTicker(period)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			for i, m := range monitors {
+				if m.idle() <= period {
+					continue
+				}
+				// Only restart when the backend is reachable; a stall
+				// during an outage is the retry loop doing its job.
+				if _, err := m.t.storeClient.GetValues(util.AppendPrefix(m.t.Prefix, m.t.Keys)); err != nil {
+					continue
+				}
+				log.Warning(fmt.Sprintf("Watch for %s stalled for %s. Restarting...", m.t.Dest, m.idle()))
+				metrics.Inc("confd_watch_restarts_total", m.t.metricLabels(map[string]string{"dest": m.t.Dest}))
+				m.stop()
+				monitors[i] = p.startMonitor(m.t)
+			}
+		}
+	}
+}
+
+// monitorOverrides re-renders every resource when the local overrides file
+// changes. The file is polled by modification time, which also covers
+// editors that replace it atomically.
+func (p *watchProcessor) monitorOverrides(ts []*TemplateResource) {
+	defer p.wg.Done()
+	var last time.Time
+	if fi, err := os.Stat(p.config.LocalOverrides); err == nil {
+		last = fi.ModTime()
+	}
+	ticker := time.NewTicker(2 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			fi, err := os.Stat(p.config.LocalOverrides)
+			if err != nil {
+				continue
+			}
+			if fi.ModTime().Equal(last) {
+				continue
+			}
+			last = fi.ModTime()
+			log.Info("Local overrides changed. Re-rendering...")
+			process(ts)
+		}
+	}
+}
+
+func (p *watchProcessor) monitorPrefix(m *watchMonitor) {
+	defer p.wg.Done()
+	t := m.t
 	keys := util.AppendPrefix(t.Prefix, t.Keys)
 	for {
-		index, err := t.storeClient.WatchPrefix(t.Prefix, keys, t.lastIndex, p.stopChan)
+		select {
+		case <-m.stopChan:
+			return
+		default:
+		}
+		index, err := t.storeClient.WatchPrefix(t.Prefix, keys, t.lastIndex, m.stopChan)
+		m.touch()
 		if err != nil {
 			p.errChan <- err
 			// Prevent backend errors from consuming all resources.
@@ -102,10 +470,92 @@ func (p *watchProcessor) monitorPrefix(t *TemplateResource) {
 			continue
 		}
 		t.lastIndex = index
-		if err := t.process(); err != nil {
+		// A per-resource batch_interval overrides the global debounce, so
+		// chatty services can batch longer without delaying unrelated ones.
+		quietSecs := p.config.Debounce
+		if t.BatchInterval != nil {
+			quietSecs = *t.BatchInterval
+		}
+		if quietSecs > 0 {
+			p.debounce(m, keys, quietSecs)
+		}
+		p.flushGroup(t)
+		m.touch()
+	}
+}
+
+// flushGroup renders t together with every other resource in its batch
+// group, so templates belonging to the same service flush as one unit. The
+// flush and its size are reported per group, keyed by dest for ungrouped
+// resources.
+func (p *watchProcessor) flushGroup(t *TemplateResource) {
+	ts := []*TemplateResource{t}
+	group := t.BatchGroup
+	if group == "" {
+		group = t.Dest
+	} else if members, ok := p.groups[group]; ok {
+		ts = members
+	}
+	for _, member := range ts {
+		start := time.Now()
+		err := member.process()
+		member.notifyRender(time.Since(start), err)
+		recordResult(member.Dest, err)
+		if err != nil {
+			metrics.Inc("confd_process_errors_total", member.metricLabels(map[string]string{"category": Categorize(err), "dest": member.Dest}))
 			p.errChan <- err
 		}
 	}
+	metrics.Inc("confd_batch_flushes_total", t.metricLabels(map[string]string{"group": group}))
+	metrics.Set("confd_batch_flush_size", t.metricLabels(map[string]string{"group": group}), float64(len(ts)))
+}
+
+// debounce absorbs a burst of changes before rendering: each further change
+// restarts the quiet period, while the max delay guarantees a render happens
+// no later than that long after the first buffered change even under a
+// steady trickle of updates.
+func (p *watchProcessor) debounce(m *watchMonitor, keys []string, quietSecs int) {
+	t := m.t
+	quiet := time.Duration(quietSecs) * time.Second
+	max := time.Duration(p.config.DebounceMax) * time.Second
+	if max <= 0 {
+		max = 10 * quiet
+	}
+	deadline := time.Now().Add(max)
+	for {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return
+		}
+		wait := quiet
+		if wait > remaining {
+			wait = remaining
+		}
+		stopWatch := make(chan bool)
+		watchDone := make(chan struct{})
+		var index uint64
+		var err error
+		go func() {
+			index, err = t.storeClient.WatchPrefix(t.Prefix, keys, t.lastIndex, stopWatch)
+			close(watchDone)
+		}()
+		select {
+		case <-watchDone:
+			if err != nil {
+				return
+			}
+			t.lastIndex = index
+			m.touch()
+		case <-time.After(wait):
+			close(stopWatch)
+			<-watchDone
+			return
+		case <-m.stopChan:
+			close(stopWatch)
+			<-watchDone
+			return
+		}
+	}
 }
 
 func getTemplateResources(config Config) ([]*TemplateResource, error) {
@@ -116,23 +566,79 @@ func getTemplateResources(config Config) ([]*TemplateResource, error) {
 		log.Warning(fmt.Sprintf("Cannot load template resources: confdir '%s' does not exist", config.ConfDir))
 		return nil, nil
 	}
-	paths, err := util.RecursiveFilesLookup(config.ConfigDir, "*toml")
-	if err != nil {
-		return nil, err
+	var paths []string
+	for _, pattern := range []string{"*toml", "*.yaml", "*.yml", "*.json"} {
+		p, err := util.RecursiveFilesLookup(config.ConfigDir, pattern)
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p...)
 	}
+	sort.Strings(paths)
 
 	if len(paths) < 1 {
 		log.Warning("Found no templates")
 	}
 
 	for _, p := range paths {
+		if filepath.Base(p) == defaultsFile {
+			continue
+		}
 		log.Debug(fmt.Sprintf("Found template: %s", p))
 		t, err := NewTemplateResource(p, config)
 		if err != nil {
 			lastError = err
 			continue
 		}
+		if !selectedResource(config, p, t.Dest) {
+			log.Debug(fmt.Sprintf("Skipping filtered resource: %s", p))
+			continue
+		}
+		if t.Iterate != "" {
+			iterated, err := ExpandIteratedResource(p, t, config)
+			if err != nil {
+				lastError = err
+				continue
+			}
+			templates = append(templates, iterated...)
+			continue
+		}
 		templates = append(templates, t)
 	}
+	if config.ManifestFile != "" {
+		syncManifest(config, templates)
+	}
 	return templates, lastError
 }
+
+// matchResource reports whether pattern globs the resource's TOML filename
+// or its dest path.
+func matchResource(pattern, path, dest string) bool {
+	if ok, _ := filepath.Match(pattern, filepath.Base(path)); ok {
+		return true
+	}
+	if ok, _ := filepath.Match(pattern, dest); ok {
+		return true
+	}
+	return false
+}
+
+// selectedResource applies the include and exclude filters to one resource:
+// with includes set the resource must match one of them, and a matching
+// exclude always wins.
+func selectedResource(config Config, path, dest string) bool {
+	for _, pattern := range config.ResourceSkip {
+		if matchResource(pattern, path, dest) {
+			return false
+		}
+	}
+	if len(config.ResourceFilter) == 0 {
+		return true
+	}
+	for _, pattern := range config.ResourceFilter {
+		if matchResource(pattern, path, dest) {
+			return true
+		}
+	}
+	return false
+}
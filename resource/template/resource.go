@@ -1,9 +1,14 @@
 package template
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"os/exec"
@@ -11,59 +16,255 @@ import (
 	"path"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"text/template"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	"github.com/abtreece/confd/backends"
 	"github.com/abtreece/confd/log"
+	"github.com/abtreece/confd/metrics"
 	util "github.com/abtreece/confd/util"
 	"github.com/kelseyhightower/memkv"
+	yaml "gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	ConfDir       string `toml:"confdir"`
-	ConfigDir     string
-	KeepStageFile bool
-	Noop          bool   `toml:"noop"`
-	Prefix        string `toml:"prefix"`
-	StoreClient   backends.StoreClient
-	SyncOnly      bool `toml:"sync-only"`
-	TemplateDir   string
+	ConfDir             string `toml:"confdir"`
+	ConfigDir           string
+	KeepStageFile       bool
+	Noop                bool   `toml:"noop"`
+	Prefix              string `toml:"prefix"`
+	NotifyWebhook       string `toml:"notify_webhook"`
+	LocalOverrides      string `toml:"local_overrides"`
+	AuditLog            string `toml:"audit_log"`
+	AuditLogSize        int    `toml:"audit_log_max_size"`
+	MaxKeys             int    `toml:"max_keys"`
+	MaxValueBytes       int    `toml:"max_value_bytes"`
+	MaxTotalBytes       int    `toml:"max_total_bytes"`
+	SecretsTmpfs        string `toml:"secrets_tmpfs"`
+	Strict              bool   `toml:"strict"`
+	Interval            int    `toml:"interval"`
+	IntervalMin         int    `toml:"interval_min"`
+	IntervalMax         int    `toml:"interval_max"`
+	IntervalBackoffMax  int    `toml:"interval_backoff_max"`
+	MaxFailures         int    `toml:"max_consecutive_failures"`
+	Splay               int    `toml:"splay"`
+	RecheckInterval     int    `toml:"recheck_interval"`
+	WatchWatchdog       int    `toml:"watch_watchdog"`
+	ManifestFile        string `toml:"manifest_file"`
+	Cleanup             bool   `toml:"cleanup"`
+	Debounce            int    `toml:"debounce"`
+	DebounceMax         int    `toml:"debounce_max"`
+	StoreClient         backends.StoreClient
+	EnvironmentPrefixes []string
+	ResourceFilter      util.Nodes
+	ResourceSkip        util.Nodes
+	DecryptCmd          string     `toml:"decrypt_cmd"`
+	FileRoots           util.Nodes `toml:"file_roots"`
+	SyncOnly            bool       `toml:"sync-only"`
+	TemplateDir         string
+	TemplateDirs        util.Nodes `toml:"template_dirs"`
 }
 
 // TemplateResourceConfig holds the parsed template resource.
 type TemplateResourceConfig struct {
-	TemplateResource TemplateResource `toml:"template"`
+	TemplateResource TemplateResource `toml:"template" yaml:"template" json:"template"`
+	Backend          backendOverrides `toml:"backend" yaml:"backend" json:"backend"`
+}
+
+// backendOverrides holds the per-resource [backend] settings that override
+// the process-wide timeout and retry configuration, for resources whose
+// backend needs a different budget than the rest of the process. The
+// strip_prefix and add_prefix settings remap this resource's keys before
+// queries, so a shared template works against differently structured data.
+type backendOverrides struct {
+	RequestTimeout    int     `toml:"request_timeout" yaml:"request_timeout" json:"request_timeout"`
+	MaxRetries        int     `toml:"max_retries" yaml:"max_retries" json:"max_retries"`
+	RetryBaseDelay    int     `toml:"retry_base_delay" yaml:"retry_base_delay" json:"retry_base_delay"`
+	RetryMaxDelay     int     `toml:"retry_max_delay" yaml:"retry_max_delay" json:"retry_max_delay"`
+	RetryJitterFactor float64 `toml:"retry_jitter_factor" yaml:"retry_jitter_factor" json:"retry_jitter_factor"`
+	StripPrefix       string  `toml:"strip_prefix" yaml:"strip_prefix" json:"strip_prefix"`
+	AddPrefix         string  `toml:"add_prefix" yaml:"add_prefix" json:"add_prefix"`
 }
 
 // TemplateResource is the representation of a parsed template resource.
 type TemplateResource struct {
-	CheckCmd      string `toml:"check_cmd"`
-	Dest          string
-	FileMode      os.FileMode
-	Gid           int
-	Group         string
-	Keys          []string
-	Mode          string
-	Owner         string
-	Prefix        string
-	ReloadCmd     string `toml:"reload_cmd"`
-	Src           string
-	StageFile     *os.File
-	Uid           int
-	funcMap       map[string]interface{}
-	lastIndex     uint64
-	keepStageFile bool
-	noop          bool
-	store         memkv.Store
-	storeClient   backends.StoreClient
-	syncOnly      bool
+	BatchGroup        string            `toml:"batch_group" yaml:"batch_group" json:"batch_group"`
+	BatchInterval     *int              `toml:"batch_interval" yaml:"batch_interval" json:"batch_interval"`
+	CheckCmd          string            `toml:"check_cmd" yaml:"check_cmd" json:"check_cmd"`
+	CleanupOrphans    bool              `toml:"cleanup_orphans" yaml:"cleanup_orphans" json:"cleanup_orphans"`
+	CmdChroot         string            `toml:"cmd_chroot" yaml:"cmd_chroot" json:"cmd_chroot"`
+	CmdDir            string            `toml:"cmd_dir" yaml:"cmd_dir" json:"cmd_dir"`
+	CmdEnv            map[string]string `toml:"cmd_env" yaml:"cmd_env" json:"cmd_env"`
+	CmdEnvAllow       []string          `toml:"cmd_env_allowlist" yaml:"cmd_env_allowlist" json:"cmd_env_allowlist"`
+	CmdNoNewPrivs     bool              `toml:"cmd_no_new_privs" yaml:"cmd_no_new_privs" json:"cmd_no_new_privs"`
+	Dest              string
+	Durability        string `toml:"durability" yaml:"durability" json:"durability"`
+	FileMode          os.FileMode
+	Format            string `toml:"format" yaml:"format" json:"format"`
+	Gid               int
+	Group             string
+	IgnoreEnvironment bool   `toml:"ignore_environment" yaml:"ignore_environment" json:"ignore_environment"`
+	Immutable         bool   `toml:"immutable" yaml:"immutable" json:"immutable"`
+	Iterate           string `toml:"iterate" yaml:"iterate" json:"iterate"`
+	Keys              []string
+	KeyTypes          map[string]string `toml:"key_types" yaml:"key_types" json:"key_types"`
+	LeftDelim         string            `toml:"left_delim" yaml:"left_delim" json:"left_delim"`
+	MetricsLabels     map[string]string `toml:"metrics_labels" yaml:"metrics_labels" json:"metrics_labels"`
+	Mode              string
+	Noop              *bool  `toml:"noop" yaml:"noop" json:"noop"`
+	OnFailureCmd      string `toml:"on_failure_cmd" yaml:"on_failure_cmd" json:"on_failure_cmd"`
+	OnSuccessCmd      string `toml:"on_success_cmd" yaml:"on_success_cmd" json:"on_success_cmd"`
+	Output            string `toml:"output" yaml:"output" json:"output"`
+	Owner             string
+	PKI               *PKIConfig `toml:"pki" yaml:"pki" json:"pki"`
+	Prefix            string
+	Prefixes          []string `toml:"prefixes" yaml:"prefixes" json:"prefixes"`
+	ReloadCmd         string   `toml:"reload_cmd" yaml:"reload_cmd" json:"reload_cmd"`
+	ReloadSemaphore   string   `toml:"reload_semaphore" yaml:"reload_semaphore" json:"reload_semaphore"`
+	ReloadSemLimit    int      `toml:"reload_semaphore_limit" yaml:"reload_semaphore_limit" json:"reload_semaphore_limit"`
+	RightDelim        string   `toml:"right_delim" yaml:"right_delim" json:"right_delim"`
+	Sensitive         bool     `toml:"sensitive" yaml:"sensitive" json:"sensitive"`
+	Schema            string   `toml:"schema" yaml:"schema" json:"schema"`
+	Src               string
+	StageFile         *os.File
+	Strict            *bool `toml:"strict" yaml:"strict" json:"strict"`
+	SyncOnly          *bool `toml:"sync_only" yaml:"sync_only" json:"sync_only"`
+	Uid               int
+	When              string `toml:"when" yaml:"when" json:"when"`
+	WhenFalse         string `toml:"when_false" yaml:"when_false" json:"when_false"`
+	funcMap           map[string]interface{}
+	lastIndex         uint64
+	keepStageFile     bool
+	noop              bool
+	notifyWebhook     string
+	localOverrides    string
+	auditPath         string
+	auditMaxBytes     int64
+	maxKeys           int
+	maxValueBytes     int
+	maxTotalBytes     int
+	destTemplate      string
+	decryptCmd        string
+	fileRoots         []string
+	secretsDir        string
+	secrets           map[string]bool
+	store             memkv.Store
+	storeClient       backends.StoreClient
+	storeDigest       string
+	storeRevision     uint64
+	strict            bool
+	storeKeys         map[string]bool
+	prevVars          map[string]string
+	currentVars       map[string]string
+	changedKeys       []string
+	syncOnly          bool
+	updated           bool
+	stateMu           sync.Mutex
+	lastState         *util.FileInfo
+	keyExpiry         time.Time
 }
 
 var ErrEmptySrc = errors.New("empty src template")
 
+// defaultsFile in the conf.d directory holds template resource settings
+// inherited by every resource unless the resource overrides them.
+const defaultsFile = "_defaults.toml"
+
+func init() {
+	metrics.Register("confd_render_bytes_total", "Total bytes rendered into stage files.", "counter")
+	metrics.Register("confd_render_peak_bytes", "Largest single render in bytes since start.", "gauge")
+	metrics.Register("confd_value_guard_rejections_total", "Backend reads rejected by the key count or value size guards.", "counter")
+	metrics.Register("confd_process_errors_total", "Template resource processing errors by category.", "counter")
+	metrics.Register("confd_drift_repairs_total", "Dest files repaired after drifting from the last rendered state.", "counter")
+	metrics.Register("confd_dest_content_hash", "First 32 bits of the MD5 of each dest file's last rendered content, for spotting fleet divergence.", "gauge")
+	metrics.Register("confd_expiring_keys", "Number of keys fetched on the last cycle that carry TTL or lease expiry metadata.", "gauge")
+}
+
+// renderPeak tracks the largest render seen so far for the peak gauge.
+var renderPeak = struct {
+	sync.Mutex
+	bytes int64
+}{}
+
+// recordRenderSize accounts a finished render of n bytes.
+func recordRenderSize(n int64) {
+	metrics.Add("confd_render_bytes_total", nil, float64(n))
+	renderPeak.Lock()
+	if n > renderPeak.bytes {
+		renderPeak.bytes = n
+		metrics.Set("confd_render_peak_bytes", nil, float64(n))
+	}
+	renderPeak.Unlock()
+}
+
+// countingWriter counts bytes written through it so render sizes can be
+// reported without holding the rendered output in memory.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// renderState caches a fingerprint of the last successful render keyed by
+// dest. When the backend values and the source template are unchanged since
+// the last run the full stage/compare cycle can be skipped, which saves a
+// render and a byte-for-byte comparison per resource per interval.
+var renderState = struct {
+	sync.Mutex
+	fingerprints map[string]string
+	destHashes   map[string]string
+}{
+	fingerprints: make(map[string]string),
+	destHashes:   make(map[string]string),
+}
+
+// fingerprint returns a digest over the resource prefix, the source template
+// modification time, and the values retrieved from the backend.
+func (t *TemplateResource) fingerprint() (string, error) {
+	fi, err := os.Stat(t.Src)
+	if err != nil {
+		return "", err
+	}
+	h := md5.New()
+	io.WriteString(h, t.Prefix)
+	io.WriteString(h, fi.ModTime().String())
+	io.WriteString(h, t.storeDigest)
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// decodeResourceConfig parses the template resource definition at path into
+// tc. The format is detected by file extension: .toml, .yaml/.yml, and .json
+// definitions all share the same schema.
+func decodeResourceConfig(path string, tc *TemplateResourceConfig) error {
+	switch filepath.Ext(path) {
+	case ".yaml", ".yml":
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return yaml.Unmarshal(data, tc)
+	case ".json":
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return json.Unmarshal(data, tc)
+	default:
+		_, err := toml.DecodeFile(path, tc)
+		return err
+	}
+}
+
 // NewTemplateResource creates a TemplateResource.
 func NewTemplateResource(path string, config Config) (*TemplateResource, error) {
 	if config.StoreClient == nil {
@@ -72,10 +273,20 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 
 	// Set the default uid and gid so we can determine if it was
 	// unset from configuration.
-	tc := &TemplateResourceConfig{TemplateResource{Uid: -1, Gid: -1}}
+	tc := &TemplateResourceConfig{TemplateResource: TemplateResource{Uid: -1, Gid: -1}}
+
+	// Settings in the conf.d defaults file are decoded first; the resource
+	// definition is then decoded over them, so it only overrides the
+	// settings it declares.
+	defaults := filepath.Join(config.ConfigDir, defaultsFile)
+	if util.IsFileExist(defaults) {
+		if _, err := toml.DecodeFile(defaults, tc); err != nil {
+			return nil, fmt.Errorf("Cannot process defaults file %s - %s", defaults, err.Error())
+		}
+	}
 
 	log.Debug("Loading template resource from " + path)
-	_, err := toml.DecodeFile(path, &tc)
+	err := decodeResourceConfig(path, tc)
 	if err != nil {
 		return nil, fmt.Errorf("Cannot process template resource %s - %s", path, err.Error())
 	}
@@ -83,11 +294,52 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 	tr := tc.TemplateResource
 	tr.keepStageFile = config.KeepStageFile
 	tr.noop = config.Noop
+	if tr.Noop != nil {
+		tr.noop = *tr.Noop
+	}
 	tr.storeClient = config.StoreClient
+	if o := tc.Backend; o.RequestTimeout > 0 || o.MaxRetries > 0 {
+		tr.storeClient = backends.WithOverrides(tr.storeClient, backends.Config{
+			RequestTimeout:    o.RequestTimeout,
+			MaxRetries:        o.MaxRetries,
+			RetryBaseDelay:    o.RetryBaseDelay,
+			RetryMaxDelay:     o.RetryMaxDelay,
+			RetryJitterFactor: o.RetryJitterFactor,
+		})
+	}
+	if o := tc.Backend; o.StripPrefix != "" || o.AddPrefix != "" {
+		tr.storeClient = backends.WithPrefixRewrite(tr.storeClient, o.StripPrefix, o.AddPrefix)
+	}
 	tr.funcMap = newFuncMap()
 	tr.store = memkv.New()
 	tr.syncOnly = config.SyncOnly
+	if tr.SyncOnly != nil {
+		tr.syncOnly = *tr.SyncOnly
+	}
+	tr.notifyWebhook = config.NotifyWebhook
+	tr.localOverrides = config.LocalOverrides
+	tr.auditPath = config.AuditLog
+	tr.auditMaxBytes = int64(config.AuditLogSize) * 1024 * 1024
+	tr.maxKeys = config.MaxKeys
+	tr.maxValueBytes = config.MaxValueBytes
+	tr.maxTotalBytes = config.MaxTotalBytes
+	tr.secretsDir = config.SecretsTmpfs
+	tr.decryptCmd = config.DecryptCmd
+	tr.fileRoots = config.FileRoots
+	tr.strict = config.Strict
+	if tr.Strict != nil {
+		tr.strict = *tr.Strict
+	}
+	tr.secrets = make(map[string]bool)
 	addFuncs(tr.funcMap, tr.store.FuncMap)
+	tr.funcMap["secretRef"] = tr.secretRef
+	tr.funcMap["require"] = tr.require
+	tr.funcMap["getvPrev"] = tr.getvPrev
+	tr.funcMap["storeRevision"] = tr.getStoreRevision
+	tr.funcMap["changedKeys"] = tr.getChangedKeys
+	tr.funcMap["getMeta"] = tr.getMeta
+	tr.funcMap["fileExists"] = tr.fileExists
+	tr.funcMap["readFile"] = tr.readFile
 
 	if config.Prefix != "" {
 		tr.Prefix = config.Prefix
@@ -97,10 +349,71 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 		tr.Prefix = "/" + tr.Prefix
 	}
 
-	if tr.Src == "" {
+	// An environment profile layers its prefixes under every resource that
+	// neither declares its own layering nor opts out. Each layer is joined
+	// with the resource prefix, so /app under prod = ["/global", "/prod"]
+	// reads /global/app then /prod/app.
+	if len(config.EnvironmentPrefixes) > 0 && !tr.IgnoreEnvironment && len(tr.Prefixes) == 0 {
+		for _, prefix := range config.EnvironmentPrefixes {
+			layer := "/" + strings.Trim(prefix, "/")
+			if tr.Prefix != "/" {
+				layer += tr.Prefix
+			}
+			tr.Prefixes = append(tr.Prefixes, layer)
+		}
+	}
+
+	for i, prefix := range tr.Prefixes {
+		if !strings.HasPrefix(prefix, "/") {
+			tr.Prefixes[i] = "/" + prefix
+		}
+	}
+
+	if tr.Src == "" && tr.Format != "dotenv" && tr.PKI == nil {
 		return nil, ErrEmptySrc
 	}
 
+	// A PKI-only resource has no template of its own; the certificate file
+	// stands in as the dest for logs, metrics, and hooks.
+	if tr.PKI != nil {
+		if err := tr.PKI.validate(); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		if tr.Dest == "" {
+			tr.Dest = tr.PKI.CertFile
+		}
+	}
+
+	// A dest containing template syntax is re-evaluated against the fetched
+	// store on every render, so one resource can fan out to tenant-specific
+	// paths.
+	if strings.Contains(tr.Dest, "{{") {
+		tr.destTemplate = tr.Dest
+	}
+
+	switch tr.Output {
+	case "", "file", "kubernetes", "merge-patch", "json-patch":
+	default:
+		return nil, fmt.Errorf("Invalid output %q for %s - must be file, kubernetes, merge-patch, or json-patch", tr.Output, path)
+	}
+
+	switch tr.Durability {
+	case "", "fsync":
+	default:
+		return nil, fmt.Errorf("Invalid durability %q for %s - must be fsync", tr.Durability, path)
+	}
+
+	switch tr.WhenFalse {
+	case "", "skip", "remove":
+	default:
+		return nil, fmt.Errorf("Invalid when_false %q for %s - must be skip or remove", tr.WhenFalse, path)
+	}
+
+	// Schema paths are resolved relative to the conf directory.
+	if tr.Schema != "" && !filepath.IsAbs(tr.Schema) {
+		tr.Schema = filepath.Join(config.ConfDir, tr.Schema)
+	}
+
 	if tr.Uid == -1 {
 		if tr.Owner != "" {
 			u, err := user.Lookup(tr.Owner)
@@ -131,35 +444,376 @@ func NewTemplateResource(path string, config Config) (*TemplateResource, error)
 		}
 	}
 
-	tr.Src = filepath.Join(config.TemplateDir, tr.Src)
+	// Search the template dirs in order and use the first match so a shared
+	// template library can be overridden per host. The confdir templates
+	// directory remains the fallback when no template_dirs are configured or
+	// no search path contains the template.
+	dirs := config.TemplateDirs
+	if len(dirs) == 0 {
+		dirs = []string{config.TemplateDir}
+	}
+	if tr.Src != "" {
+		src := filepath.Join(dirs[0], tr.Src)
+		for _, dir := range dirs {
+			if util.IsFileExist(filepath.Join(dir, tr.Src)) {
+				src = filepath.Join(dir, tr.Src)
+				break
+			}
+		}
+		tr.Src = src
+	}
 	return &tr, nil
 }
 
+// iterationKeys returns the sorted child names under the resource prefix
+// matched by the iterate pattern.
+func (t *TemplateResource) iterationKeys() ([]string, error) {
+	base := path.Join(t.Prefix, path.Dir(t.Iterate))
+	result, err := t.storeClient.GetValues([]string{base})
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for k := range result {
+		rest := strings.TrimPrefix(strings.TrimPrefix(k, base), "/")
+		if rest == "" {
+			continue
+		}
+		name := strings.SplitN(rest, "/", 2)[0]
+		ok, err := path.Match(path.Base(t.Iterate), name)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			seen[name] = true
+		}
+	}
+	names := make([]string, 0, len(seen))
+	for name := range seen {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ExpandIteratedResource expands a template resource with an iterate pattern
+// into one resource per matched subtree. Each expanded resource is scoped to
+// the matched subtree and its dest is rendered as a template with .IterKey
+// set to the matched child name.
+func ExpandIteratedResource(resourcePath string, t *TemplateResource, config Config) ([]*TemplateResource, error) {
+	names, err := t.iterationKeys()
+	if err != nil {
+		return nil, err
+	}
+	destTmpl, err := template.New("dest").Parse(t.Dest)
+	if err != nil {
+		return nil, fmt.Errorf("Cannot parse dest template %s - %s", t.Dest, err.Error())
+	}
+	keep := make(map[string]bool, len(names))
+	resources := make([]*TemplateResource, 0, len(names))
+	for _, name := range names {
+		it, err := NewTemplateResource(resourcePath, config)
+		if err != nil {
+			return nil, err
+		}
+		it.Iterate = ""
+		var buf bytes.Buffer
+		if err := destTmpl.Execute(&buf, map[string]string{"IterKey": name}); err != nil {
+			return nil, err
+		}
+		it.Dest = buf.String()
+		it.Prefix = path.Join(t.Prefix, path.Dir(t.Iterate), name)
+		keep[it.Dest] = true
+		resources = append(resources, it)
+	}
+	// With cleanup enabled, dest files whose keys have disappeared are
+	// removed so services stop loading stale per-key configs.
+	if t.CleanupOrphans {
+		var patternBuf bytes.Buffer
+		if err := destTmpl.Execute(&patternBuf, map[string]string{"IterKey": "*"}); err == nil {
+			cleanupOrphanedDests(patternBuf.String(), keep, t.noop)
+		}
+	}
+	return resources, nil
+}
+
+// cleanupOrphanedDests removes files matching the iterated dest pattern that
+// no current key produced. Dot files are skipped so staged temp files
+// survive. In noop mode pending removals are only logged.
+func cleanupOrphanedDests(pattern string, keep map[string]bool, noop bool) {
+	matches, err := filepath.Glob(pattern)
+	if err != nil {
+		return
+	}
+	for _, match := range matches {
+		if keep[match] || strings.HasPrefix(filepath.Base(match), ".") {
+			continue
+		}
+		if noop {
+			log.Info("Noop mode enabled. " + match + " is orphaned and would be removed")
+			continue
+		}
+		if err := os.Remove(match); err != nil {
+			log.Error("Unable to remove orphaned file " + match + ": " + err.Error())
+		} else {
+			log.Info("Removed orphaned file " + match)
+		}
+	}
+}
+
 // setVars sets the Vars for template resource.
 func (t *TemplateResource) setVars() error {
-	var err error
 	log.Debug("Retrieving keys from store")
-	log.Debug("Key prefix set to " + t.Prefix)
 
-	result, err := t.storeClient.GetValues(util.AppendPrefix(t.Prefix, t.Keys))
-	if err != nil {
+	// With prefixes set, each prefix is fetched in order and later prefixes
+	// override earlier ones for matching keys, giving a hierarchical
+	// global -> region -> host override chain without template logic.
+	prefixes := t.Prefixes
+	if len(prefixes) == 0 {
+		log.Debug("Key prefix set to " + t.Prefix)
+		prefixes = []string{t.Prefix}
+	}
+	result := make(map[string]string)
+	// Values carrying TTL or lease metadata (etcd leases, expiration
+	// policies) are tracked so a re-render can be scheduled around expiry
+	// even when the backend never emits a change event.
+	expiringKeys := 0
+	var nextExpiry time.Time
+	now := time.Now()
+	for _, prefix := range prefixes {
+		fetched, err := t.storeClient.GetValues(util.AppendPrefix(prefix, t.Keys))
+		if err != nil {
+			return err
+		}
+		for k, v := range fetched {
+			result[path.Join("/", strings.TrimPrefix(k, prefix))] = v
+			if expiry, ok := backends.KeyExpiry(t.storeClient, k); ok && !expiry.IsZero() {
+				expiringKeys++
+				if expiry.After(now) && (nextExpiry.IsZero() || expiry.Before(nextExpiry)) {
+					nextExpiry = expiry
+				}
+			}
+		}
+	}
+	t.stateMu.Lock()
+	t.keyExpiry = nextExpiry
+	t.stateMu.Unlock()
+	metrics.Set("confd_expiring_keys", t.metricLabels(map[string]string{"dest": t.Dest}), float64(expiringKeys))
+	// Encrypted values are decrypted before anything else looks at them, so
+	// guards, strict checks, and templates all see plaintext.
+	if err := t.decryptVars(result); err != nil {
 		return err
 	}
+	// Declared key type assertions fail fast on malformed backend data.
+	if len(t.KeyTypes) > 0 {
+		if err := checkKeyTypes(t.KeyTypes, result); err != nil {
+			return newProcessError(ErrTemplate, t.Dest, err)
+		}
+	}
+	// Guard against runaway writers before the values reach the store: a
+	// fetch that exceeds the configured key count or byte limits fails the
+	// render instead of ballooning confd's memory.
+	if err := t.checkValueGuards(result); err != nil {
+		metrics.Inc("confd_value_guard_rejections_total", t.metricLabels(map[string]string{"dest": t.Dest}))
+		return err
+	}
+	// In strict mode a declared key that matched nothing is an error, so a
+	// typo'd prefix fails the render instead of producing an empty but
+	// syntactically valid config.
+	if t.strict {
+		if err := checkStrictKeys(t.Keys, result); err != nil {
+			return err
+		}
+	}
+	// Local overrides win over every backend value.
+	if t.localOverrides != "" && util.IsFileExist(t.localOverrides) {
+		overrides, err := loadOverrides(t.localOverrides)
+		if err != nil {
+			log.Error(err.Error())
+		} else {
+			for k, v := range overrides {
+				result[k] = v
+			}
+		}
+	}
 	log.Debug("Got the following map from store: %v", result)
 
-	t.store.Purge()
+	t.storeRevision = backends.Revision(t.storeClient)
 
+	// Rotate the snapshots and record which keys differ so templates can
+	// compare cycles via getvPrev and changedKeys.
+	t.prevVars = t.currentVars
+	t.currentVars = result
+	changed := []string{}
 	for k, v := range result {
-		t.store.Set(path.Join("/", strings.TrimPrefix(k, t.Prefix)), v)
+		if pv, ok := t.prevVars[k]; !ok || pv != v {
+			changed = append(changed, k)
+		}
+	}
+	for k := range t.prevVars {
+		if _, ok := result[k]; !ok {
+			changed = append(changed, k)
+		}
+	}
+	sort.Strings(changed)
+	t.changedKeys = changed
+
+	// Update the store in place rather than purging and refilling it, so
+	// unchanged keys are not reallocated every interval.
+	seen := make(map[string]bool, len(result))
+	keys := make([]string, 0, len(result))
+	for storeKey, v := range result {
+		seen[storeKey] = true
+		if existing, err := t.store.Get(storeKey); err != nil || existing.Value != v {
+			t.store.Set(storeKey, v)
+		}
+		keys = append(keys, storeKey)
+	}
+	for k := range t.storeKeys {
+		if !seen[k] {
+			t.store.Del(k)
+		}
+	}
+	t.storeKeys = seen
+
+	// Digest the values in a stable order so unchanged backend state always
+	// produces the same render fingerprint.
+	sort.Strings(keys)
+	h := md5.New()
+	for _, k := range keys {
+		io.WriteString(h, k)
+		io.WriteString(h, result[k])
+	}
+	t.storeDigest = fmt.Sprintf("%x", h.Sum(nil))
+	return nil
+}
+
+// checkValueGuards enforces the configured limits on the number of keys, the
+// size of any single value, and the total bytes fetched. A zero limit
+// disables that guard.
+func (t *TemplateResource) checkValueGuards(vars map[string]string) error {
+	if t.maxKeys > 0 && len(vars) > t.maxKeys {
+		return fmt.Errorf("backend returned %d keys for %s, exceeding max_keys %d", len(vars), t.Dest, t.maxKeys)
+	}
+	total := 0
+	for k, v := range vars {
+		if t.maxValueBytes > 0 && len(v) > t.maxValueBytes {
+			return fmt.Errorf("value for %s is %d bytes, exceeding max_value_bytes %d", k, len(v), t.maxValueBytes)
+		}
+		total += len(k) + len(v)
+	}
+	if t.maxTotalBytes > 0 && total > t.maxTotalBytes {
+		return fmt.Errorf("backend returned %d bytes for %s, exceeding max_total_bytes %d", total, t.Dest, t.maxTotalBytes)
+	}
+	return nil
+}
+
+// checkStrictKeys returns an error naming every declared key for which the
+// store returned no values, either as an exact match or as a subtree.
+func checkStrictKeys(keys []string, vars map[string]string) error {
+	missing := []string{}
+	for _, key := range keys {
+		key = path.Join("/", key)
+		found := false
+		for k := range vars {
+			if k == key || strings.HasPrefix(k, key+"/") {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, key)
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("strict mode: no values found for keys: %s", strings.Join(missing, ", "))
 	}
 	return nil
 }
 
+// renderDest evaluates a templated dest against the current store. The
+// rendered path must be absolute and clean - no relative segments - so a
+// malicious backend value cannot traverse outside the intended directory.
+func (t *TemplateResource) renderDest() error {
+	if t.destTemplate == "" {
+		return nil
+	}
+	tmpl, err := template.New("dest").Delims(t.LeftDelim, t.RightDelim).Funcs(t.funcMap).Parse(t.destTemplate)
+	if err != nil {
+		return fmt.Errorf("Unable to parse dest template %s - %s", t.destTemplate, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return err
+	}
+	dest := strings.TrimSpace(buf.String())
+	if dest == "" {
+		return errors.New("dest template rendered an empty path")
+	}
+	if !filepath.IsAbs(dest) || filepath.Clean(dest) != dest || strings.Contains(dest, "..") {
+		return fmt.Errorf("dest template rendered unsafe path %q", dest)
+	}
+	t.Dest = dest
+	return nil
+}
+
+// getvPrev returns the value key had in the previous render cycle, or the
+// optional default when the key was not present.
+func (t *TemplateResource) getvPrev(key string, v ...string) (string, error) {
+	if value, ok := t.prevVars[key]; ok {
+		return value, nil
+	}
+	if len(v) > 0 {
+		return v[0], nil
+	}
+	return "", fmt.Errorf("key does not exist in previous render: %s", key)
+}
+
+// getChangedKeys returns the sorted keys whose values were added, removed,
+// or changed since the previous render cycle.
+func (t *TemplateResource) getChangedKeys() []string {
+	return t.changedKeys
+}
+
+// getMeta returns the backend metadata (Flags, Session, ModifyIndex)
+// recorded for key, for backends that retain it. The key is resolved against
+// the resource prefixes the same way values are.
+func (t *TemplateResource) getMeta(key string) (map[string]interface{}, error) {
+	prefixes := t.Prefixes
+	if len(prefixes) == 0 {
+		prefixes = []string{t.Prefix}
+	}
+	// Later prefixes override earlier ones for values, so search them in
+	// reverse to report the metadata of the winning key.
+	for i := len(prefixes) - 1; i >= 0; i-- {
+		flags, session, modifyIndex, ok := backends.KeyMeta(t.storeClient, path.Join(prefixes[i], key))
+		if ok {
+			return map[string]interface{}{
+				"Flags":       flags,
+				"Session":     session,
+				"ModifyIndex": modifyIndex,
+			}, nil
+		}
+	}
+	return nil, fmt.Errorf("no metadata recorded for key: %s", key)
+}
+
+// getStoreRevision returns the backend revision or query index the current
+// values were read at, for backends that report one.
+func (t *TemplateResource) getStoreRevision() uint64 {
+	return t.storeRevision
+}
+
 // createStageFile stages the src configuration file by processing the src
 // template and setting the desired owner, group, and mode. It also sets the
 // StageFile for the template resource.
 // It returns an error if any.
 func (t *TemplateResource) createStageFile() error {
+	if t.Format == "dotenv" {
+		return t.createDotenvStageFile()
+	}
+
 	log.Debug("Using source template " + t.Src)
 
 	if !util.IsFileExist(t.Src) {
@@ -168,22 +822,52 @@ func (t *TemplateResource) createStageFile() error {
 
 	log.Debug("Compiling source template " + t.Src)
 
-	tmpl, err := template.New(filepath.Base(t.Src)).Funcs(t.funcMap).ParseFiles(t.Src)
+	// Custom delimiters keep templates that themselves generate Go template
+	// syntax readable. Empty strings leave the {{ }} defaults in place.
+	tmpl, err := template.New(filepath.Base(t.Src)).Delims(t.LeftDelim, t.RightDelim).Funcs(t.funcMap).ParseFiles(t.Src)
 	if err != nil {
 		return fmt.Errorf("Unable to process template %s, %s", t.Src, err)
 	}
 
 	// create TempFile in Dest directory to avoid cross-filesystem issues
-	temp, err := ioutil.TempFile(filepath.Dir(t.Dest), "."+filepath.Base(t.Dest))
+	stageDir := filepath.Dir(t.Dest)
+	if t.Sensitive {
+		// Sensitive renders stage on tmpfs and run with process memory
+		// locked, so secret content never touches a disk-backed path or
+		// swap.
+		if err := t.prepareSensitiveStaging(); err != nil {
+			return err
+		}
+		stageDir = t.secretsDir
+	}
+	temp, err := ioutil.TempFile(stageDir, "."+filepath.Base(t.Dest))
 	if err != nil {
 		return err
 	}
 
-	if err = tmpl.Execute(temp, nil); err != nil {
+	// Stream the render through a buffered writer so large outputs are never
+	// held fully in memory. Sensitive renders write straight to the tmpfs
+	// file instead of through an intermediate heap buffer.
+	counted := &countingWriter{w: temp}
+	var out io.Writer = counted
+	var buffered *bufio.Writer
+	if !t.Sensitive {
+		buffered = bufio.NewWriterSize(counted, 64*1024)
+		out = buffered
+	}
+	if err = tmpl.Execute(out, nil); err != nil {
 		temp.Close()
 		os.Remove(temp.Name())
 		return err
 	}
+	if buffered != nil {
+		if err = buffered.Flush(); err != nil {
+			temp.Close()
+			os.Remove(temp.Name())
+			return err
+		}
+	}
+	recordRenderSize(counted.n)
 	defer temp.Close()
 
 	// Set the owner, group, and mode on the stage file now to make it easier to
@@ -194,12 +878,77 @@ func (t *TemplateResource) createStageFile() error {
 	return nil
 }
 
+// lockMemoryOnce attempts to lock process memory a single time; failure is
+// logged and rendering continues, matching the "where possible" contract.
+var lockMemoryOnce sync.Once
+
+// prepareSensitiveStaging verifies the configured secrets directory is
+// memory backed and locks process memory where possible.
+func (t *TemplateResource) prepareSensitiveStaging() error {
+	if t.secretsDir == "" {
+		return errors.New("sensitive resource needs a secrets-tmpfs directory")
+	}
+	ok, err := util.IsTmpfs(t.secretsDir)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return errors.New(t.secretsDir + " is not memory backed; refusing to stage sensitive content there")
+	}
+	lockMemoryOnce.Do(func() {
+		if err := util.LockMemory(); err != nil {
+			log.Debug("Unable to lock process memory: " + err.Error())
+		}
+	})
+	return nil
+}
+
+// createDotenvStageFile stages a dotenv rendering of the resource keys
+// without requiring a source template. Key paths are converted to
+// environment style names and values are double quoted with escaping.
+func (t *TemplateResource) createDotenvStageFile() error {
+	log.Debug("Rendering dotenv output for " + t.Dest)
+
+	keys := make([]string, 0, len(t.storeKeys))
+	for k := range t.storeKeys {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	temp, err := ioutil.TempFile(filepath.Dir(t.Dest), "."+filepath.Base(t.Dest))
+	if err != nil {
+		return err
+	}
+	defer temp.Close()
+	counted := &countingWriter{w: temp}
+	buffered := bufio.NewWriterSize(counted, 64*1024)
+	for _, k := range keys {
+		v, err := t.store.GetValue(k)
+		if err != nil {
+			continue
+		}
+		name := strings.ToUpper(strings.Replace(strings.Trim(k, "/"), "/", "_", -1))
+		fmt.Fprintf(buffered, "%s=%q\n", name, v)
+	}
+	if err := buffered.Flush(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	recordRenderSize(counted.n)
+
+	os.Chmod(temp.Name(), t.FileMode)
+	os.Chown(temp.Name(), t.Uid, t.Gid)
+	t.StageFile = temp
+	return nil
+}
+
 // sync compares the staged and dest config files and attempts to sync them
 // if they differ. sync will run a config check command if set before
 // overwriting the target config file. Finally, sync will run a reload command
 // if set to have the application or service pick up the changes.
 // It returns an error if any.
 func (t *TemplateResource) sync() error {
+	t.updated = false
 	staged := t.StageFile.Name()
 	if t.keepStageFile {
 		log.Info("Keeping staged file: " + staged)
@@ -207,6 +956,17 @@ func (t *TemplateResource) sync() error {
 		defer os.Remove(staged)
 	}
 
+	// Hold an advisory lock on the dest for the compare-and-replace cycle so
+	// cooperating writers do not race with the rename.
+	if util.IsFileExist(t.Dest) {
+		unlock, err := util.LockFile(t.Dest)
+		if err != nil {
+			log.Debug("Unable to lock " + t.Dest + ": " + err.Error())
+		} else {
+			defer unlock()
+		}
+	}
+
 	log.Debug("Comparing candidate config to " + t.Dest)
 	ok, err := util.IsConfigChanged(staged, t.Dest)
 	if err != nil {
@@ -214,20 +974,52 @@ func (t *TemplateResource) sync() error {
 	}
 	if t.noop {
 		log.Warning("Noop mode enabled. " + t.Dest + " will not be modified")
+		if contents, rerr := ioutil.ReadFile(staged); rerr == nil {
+			log.Debug("Pending content for " + t.Dest + ":\n" + t.maskSecrets(string(contents)))
+		}
 		return nil
 	}
 	if ok {
 		log.Info("Target config " + t.Dest + " out of sync")
 		if !t.syncOnly && t.CheckCmd != "" {
 			if err := t.check(); err != nil {
-				return errors.New("Config check failed: " + err.Error())
+				return newProcessError(ErrCheckFailed, t.Dest, errors.New("Config check failed: "+err.Error()))
+			}
+		}
+		// An immutable dest is protected with chattr +i between renders. The
+		// attribute must still be present before a replace - a missing
+		// attribute means the protection was tampered with - and is dropped
+		// only for the swap itself.
+		if t.Immutable && util.IsFileExist(t.Dest) {
+			immutable, err := util.IsImmutable(t.Dest)
+			if err != nil {
+				return err
+			}
+			if !immutable {
+				return errors.New("Refusing to replace " + t.Dest + ": expected immutable attribute is not set")
+			}
+			if err := util.SetImmutable(t.Dest, false); err != nil {
+				return err
 			}
 		}
+		var oldHash string
+		if fi, ferr := util.FileStat(t.Dest); ferr == nil {
+			oldHash = fi.Md5
+		}
 		log.Debug("Overwriting target config " + t.Dest)
+		// With durability = "fsync" the staged content is flushed to disk
+		// before the rename, so the dest entry can never point at an
+		// unwritten inode after power loss.
+		if t.Durability == "fsync" {
+			if err := util.Fsync(staged); err != nil {
+				return err
+			}
+		}
 		err := os.Rename(staged, t.Dest)
 		if err != nil {
-			if strings.Contains(err.Error(), "device or resource busy") {
-				log.Debug("Rename failed - target is likely a mount. Trying to write instead")
+			if strings.Contains(err.Error(), "device or resource busy") ||
+				strings.Contains(err.Error(), "cross-device link") {
+				log.Debug("Rename failed - target is likely a mount or on another filesystem. Trying to write instead")
 				// try to open the file and write to it
 				var contents []byte
 				var rerr error
@@ -245,18 +1037,80 @@ func (t *TemplateResource) sync() error {
 				return err
 			}
 		}
-		if !t.syncOnly && t.ReloadCmd != "" {
-			if err := t.reload(); err != nil {
+		// Flushing the new content and its directory entry makes the
+		// replacement durable across power loss on hosts with aggressive
+		// write-back caching.
+		if t.Durability == "fsync" {
+			if err := util.Fsync(t.Dest); err != nil {
 				return err
 			}
+			if err := util.FsyncDir(filepath.Dir(t.Dest)); err != nil {
+				return err
+			}
+		}
+		if t.Immutable {
+			if err := util.SetImmutable(t.Dest, true); err != nil {
+				return errors.New("Unable to re-apply immutable attribute on " + t.Dest + ": " + err.Error())
+			}
+		}
+		var reloadErr error
+		if !t.syncOnly && t.ReloadCmd != "" {
+			reloadErr = t.reload()
 		}
+		t.audit(oldHash, reloadErr)
+		if reloadErr != nil {
+			return newProcessError(ErrReloadFailed, t.Dest, reloadErr)
+		}
+		t.updated = true
 		log.Info("Target config " + t.Dest + " has been updated")
+		t.recordRenderedState()
 	} else {
 		log.Debug("Target config " + t.Dest + " in sync")
+		t.recordRenderedState()
 	}
 	return nil
 }
 
+// recordRenderedState remembers the dest file's owner, mode, and content
+// hash after a successful sync so drift rechecks have a baseline.
+func (t *TemplateResource) recordRenderedState() {
+	fi, err := util.FileStat(t.Dest)
+	if err != nil {
+		return
+	}
+	t.stateMu.Lock()
+	t.lastState = &fi
+	t.stateMu.Unlock()
+	recordDestHash(t.Dest, fi.Md5, t.MetricsLabels)
+}
+
+// nextKeyExpiry returns the earliest future lease or TTL expiry among the
+// keys fetched on the last cycle, or the zero time when none of them carry
+// expiry metadata.
+func (t *TemplateResource) nextKeyExpiry() time.Time {
+	t.stateMu.Lock()
+	defer t.stateMu.Unlock()
+	return t.keyExpiry
+}
+
+// drifted reports whether the dest file no longer matches the state confd
+// last rendered - its content hash, mode, or ownership changed underneath
+// us, or the file disappeared. Resources that have not rendered yet never
+// count as drifted.
+func (t *TemplateResource) drifted() bool {
+	t.stateMu.Lock()
+	last := t.lastState
+	t.stateMu.Unlock()
+	if last == nil {
+		return false
+	}
+	fi, err := util.FileStat(t.Dest)
+	if err != nil {
+		return true
+	}
+	return fi.Md5 != last.Md5 || fi.Mode != last.Mode || fi.Uid != last.Uid || fi.Gid != last.Gid
+}
+
 // check executes the check command to validate the staged config file. The
 // command is modified so that any references to src template are substituted
 // with a string representing the full path of the staged file. This allows the
@@ -274,20 +1128,121 @@ func (t *TemplateResource) check() error {
 	if err := tmpl.Execute(&cmdBuffer, data); err != nil {
 		return err
 	}
-	return runCommand(cmdBuffer.String())
+	env, err := t.cmdEnviron()
+	if err != nil {
+		return err
+	}
+	return runCommand(cmdBuffer.String(), env, t.cmdOptions())
 }
 
-// reload executes the reload command.
+// reload executes the reload command. With reload_semaphore set it first
+// claims a slot of the named Consul semaphore, so a fleet sharing the
+// prefix never reloads more than reload_semaphore_limit nodes at once.
 // It returns nil if the reload command returns 0.
 func (t *TemplateResource) reload() error {
-	return runCommand(t.ReloadCmd)
+	env, err := t.cmdEnviron()
+	if err != nil {
+		return err
+	}
+	if t.ReloadSemaphore != "" {
+		limit := t.ReloadSemLimit
+		if limit <= 0 {
+			limit = 1
+		}
+		log.Debug("Waiting for a slot of the reload semaphore " + t.ReloadSemaphore)
+		release, err := backends.AcquireSemaphore(t.storeClient, t.ReloadSemaphore, limit)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := release(); err != nil {
+				log.Error("Unable to release reload semaphore slot: " + err.Error())
+			}
+		}()
+	}
+	return runCommand(t.ReloadCmd, env, t.cmdOptions())
+}
+
+// cmdEnviron returns the environment for check_cmd and reload_cmd: the confd
+// environment extended with the rendered cmd_env entries. Entry values are
+// templates with access to the same function set as the resource template,
+// so backend values can be passed to commands without shell quoting.
+func (t *TemplateResource) cmdEnviron() ([]string, error) {
+	if len(t.CmdEnv) == 0 && len(t.CmdEnvAllow) == 0 {
+		return nil, nil
+	}
+	env := os.Environ()
+	// With an allowlist configured only the listed variables are inherited,
+	// so a compromised backend value interpolated into a command sees as
+	// little of the process environment as possible.
+	if len(t.CmdEnvAllow) > 0 {
+		allowed := make(map[string]bool, len(t.CmdEnvAllow))
+		for _, name := range t.CmdEnvAllow {
+			allowed[name] = true
+		}
+		filtered := env[:0]
+		for _, e := range env {
+			if idx := strings.Index(e, "="); idx > 0 && allowed[e[:idx]] {
+				filtered = append(filtered, e)
+			}
+		}
+		env = filtered
+	}
+	names := make([]string, 0, len(t.CmdEnv))
+	for name := range t.CmdEnv {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		tmpl, err := template.New("cmd_env").Delims(t.LeftDelim, t.RightDelim).Funcs(t.funcMap).Parse(t.CmdEnv[name])
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, nil); err != nil {
+			return nil, err
+		}
+		env = append(env, name+"="+buf.String())
+	}
+	return env, nil
+}
+
+// cmdOptions carries the hardening options applied to check, reload, and
+// hook commands.
+type cmdOptions struct {
+	noNewPrivs bool
+	chroot     string
+	dir        string
+}
+
+// cmdOptions returns the hardening options configured on the resource.
+func (t *TemplateResource) cmdOptions() cmdOptions {
+	return cmdOptions{noNewPrivs: t.CmdNoNewPrivs, chroot: t.CmdChroot, dir: t.CmdDir}
+}
+
+// noNewPrivsState applies the no_new_privs flag at most once; it is
+// process-wide and irreversible, so there is nothing to undo per command.
+var noNewPrivsState struct {
+	once sync.Once
+	err  error
+}
+
+func applyNoNewPrivs() error {
+	noNewPrivsState.once.Do(func() {
+		noNewPrivsState.err = util.NoNewPrivs()
+		if noNewPrivsState.err == nil {
+			log.Debug("no_new_privs set; commands can no longer gain privileges")
+		}
+	})
+	return noNewPrivsState.err
 }
 
 // runCommand is a shared function used by check and reload
-// to run the given command and log its output.
+// to run the given command and log its output. A non-nil env replaces the
+// inherited process environment.
 // It returns nil if the given cmd returns 0.
 // The command can be run on unix and windows.
-func runCommand(cmd string) error {
+func runCommand(cmd string, env []string, opts cmdOptions) error {
 	log.Debug("Running " + cmd)
 	var c *exec.Cmd
 	if runtime.GOOS == "windows" {
@@ -295,6 +1250,22 @@ func runCommand(cmd string) error {
 	} else {
 		c = exec.Command("/bin/sh", "-c", cmd)
 	}
+	if env != nil {
+		c.Env = env
+	}
+	if opts.dir != "" {
+		c.Dir = opts.dir
+	}
+	if opts.chroot != "" {
+		if err := util.SetChroot(c, opts.chroot); err != nil {
+			return err
+		}
+	}
+	if opts.noNewPrivs {
+		if err := applyNoNewPrivs(); err != nil {
+			return err
+		}
+	}
 
 	output, err := c.CombinedOutput()
 	if err != nil {
@@ -305,27 +1276,268 @@ func runCommand(cmd string) error {
 	return nil
 }
 
+// evaluateWhen renders the resource's when expression against the fetched
+// backend values and reports whether the resource should be processed, so
+// feature-flagged services can gate file management without wrapper
+// scripts. The expression must render a boolean.
+func (t *TemplateResource) evaluateWhen() (bool, error) {
+	tmpl, err := template.New("when").Delims(t.LeftDelim, t.RightDelim).Funcs(t.funcMap).Parse(t.When)
+	if err != nil {
+		return false, fmt.Errorf("invalid when expression %q: %s", t.When, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, nil); err != nil {
+		return false, err
+	}
+	keep, err := strconv.ParseBool(strings.TrimSpace(buf.String()))
+	if err != nil {
+		return false, fmt.Errorf("when expression %q must render a boolean, got %q", t.When, strings.TrimSpace(buf.String()))
+	}
+	return keep, nil
+}
+
+// skipWhenFalse handles a resource whose when expression evaluated false:
+// the render is skipped, and with when_false = "remove" an existing dest is
+// deleted so disabled services do not keep stale configs.
+func (t *TemplateResource) skipWhenFalse() error {
+	log.Debug("Skipping " + t.Dest + ": when expression is false")
+	if t.WhenFalse != "remove" || !util.IsFileExist(t.Dest) {
+		return nil
+	}
+	if t.noop {
+		log.Warning("Noop mode enabled. " + t.Dest + " will not be removed")
+		return nil
+	}
+	if err := os.Remove(t.Dest); err != nil {
+		return newProcessError(ErrFS, t.Dest, err)
+	}
+	log.Info("Removed " + t.Dest + ": when expression is false")
+	t.updated = true
+	return nil
+}
+
 // process is a convenience function that wraps calls to the three main tasks
 // required to keep local configuration files in sync. First we gather vars
 // from the store, then we stage a candidate configuration file, and finally sync
 // things up.
-// It returns an error if any.
-func (t *TemplateResource) process() error {
+// It returns an error if any. The per-resource success and failure hooks run
+// after processing completes, keyed on the outcome.
+func (t *TemplateResource) process() (err error) {
+	defer func() { t.runHooks(err) }()
+	if t.PKI != nil {
+		if err := t.processPKI(); err != nil {
+			return err
+		}
+		// A PKI-only resource is done once its certificate is current.
+		if t.Src == "" && t.Format != "dotenv" {
+			return nil
+		}
+	}
 	if err := t.setFileMode(); err != nil {
-		return err
+		return newProcessError(ErrFS, t.Dest, err)
 	}
 	if err := t.setVars(); err != nil {
-		return err
+		return newProcessError(ErrBackendUnreachable, t.Dest, err)
+	}
+	if t.When != "" {
+		keep, err := t.evaluateWhen()
+		if err != nil {
+			return newProcessError(ErrTemplate, t.Dest, err)
+		}
+		if !keep {
+			return t.skipWhenFalse()
+		}
+	}
+	if err := t.renderDest(); err != nil {
+		return newProcessError(ErrTemplate, t.Dest, err)
+	}
+	t.checkExternalModification()
+	fp, err := t.fingerprint()
+	if err == nil {
+		renderState.Lock()
+		cached := renderState.fingerprints[t.Dest]
+		renderState.Unlock()
+		if cached == fp {
+			log.Debug("Values and template for " + t.Dest + " unchanged since last render. Skipping")
+			return nil
+		}
 	}
 	if err := t.createStageFile(); err != nil {
-		return err
+		return newProcessError(ErrTemplate, t.Dest, err)
 	}
-	if err := t.sync(); err != nil {
-		return err
+	if t.Schema != "" {
+		if err := t.validateSchema(); err != nil {
+			return newProcessError(ErrTemplate, t.Dest, err)
+		}
+	}
+	if t.Output == "kubernetes" {
+		if err := t.applyStageFile(); err != nil {
+			return newProcessError(ErrFS, t.Dest, err)
+		}
+	} else {
+		// Patch outputs rewrite the stage file to the patched dest content
+		// first, then the usual compare-and-sync cycle runs on the result.
+		if t.Output == "merge-patch" || t.Output == "json-patch" {
+			if err := t.applyJSONPatch(); err != nil {
+				return newProcessError(ErrTemplate, t.Dest, err)
+			}
+		}
+		if err := t.sync(); err != nil {
+			return newProcessError(ErrFS, t.Dest, err)
+		}
+	}
+	if fp != "" && !t.noop {
+		renderState.Lock()
+		renderState.fingerprints[t.Dest] = fp
+		if fi, err := util.FileStat(t.Dest); err == nil {
+			renderState.destHashes[t.Dest] = fi.Md5
+		}
+		renderState.Unlock()
 	}
 	return nil
 }
 
+// metricLabels extends labels with the static metrics_labels declared by
+// the resource, so its metric series and audit records can be sliced by
+// service or tier without parsing dest paths.
+func (t *TemplateResource) metricLabels(labels map[string]string) map[string]string {
+	if len(t.MetricsLabels) == 0 {
+		return labels
+	}
+	merged := make(map[string]string, len(labels)+len(t.MetricsLabels))
+	for k, v := range t.MetricsLabels {
+		merged[k] = v
+	}
+	for k, v := range labels {
+		merged[k] = v
+	}
+	return merged
+}
+
+// audit records a completed replacement of dest in the audit log, when one
+// is configured.
+func (t *TemplateResource) audit(oldHash string, reloadErr error) {
+	if t.auditPath == "" {
+		return
+	}
+	e := auditEvent{
+		Timestamp:    time.Now().UTC().Format(time.RFC3339),
+		Dest:         t.Dest,
+		Labels:       t.MetricsLabels,
+		OldHash:      oldHash,
+		ChangedKeys:  t.changedKeys,
+		BackendIndex: t.storeRevision,
+	}
+	if fi, err := util.FileStat(t.Dest); err == nil {
+		e.NewHash = fi.Md5
+	}
+	if !t.syncOnly && t.ReloadCmd != "" {
+		e.ReloadResult = "ok"
+		if reloadErr != nil {
+			e.ReloadResult = reloadErr.Error()
+		}
+	}
+	writeAudit(t.auditPath, t.auditMaxBytes, e)
+}
+
+// runHooks runs on_failure_cmd when processing returned an error, and
+// on_success_cmd when the dest was updated and reloaded. The hooks receive
+// CONFD_DEST in their environment; the failure hook also gets CONFD_ERROR.
+// Hook failures are logged but never affect the processing result.
+func (t *TemplateResource) runHooks(err error) {
+	var cmd string
+	extra := []string{"CONFD_DEST=" + t.Dest}
+	switch {
+	case err != nil && t.OnFailureCmd != "":
+		cmd = t.OnFailureCmd
+		extra = append(extra, "CONFD_ERROR="+err.Error())
+	case err == nil && t.updated && t.OnSuccessCmd != "":
+		cmd = t.OnSuccessCmd
+	default:
+		return
+	}
+	env, eerr := t.cmdEnviron()
+	if eerr != nil {
+		log.Error("Unable to build hook environment: " + eerr.Error())
+		return
+	}
+	if env == nil {
+		env = os.Environ()
+	}
+	if herr := runCommand(cmd, append(env, extra...), t.cmdOptions()); herr != nil {
+		log.Error("Hook command failed for " + t.Dest + ": " + herr.Error())
+	}
+}
+
+// secretRef looks up a key like getv but marks the returned value as
+// sensitive so diff, log, and noop output mask it. The real value is still
+// written to dest.
+func (t *TemplateResource) secretRef(key string) (string, error) {
+	v, err := t.store.GetValue(key)
+	if err != nil {
+		return "", err
+	}
+	t.secrets[v] = true
+	return v, nil
+}
+
+// require looks up a key like getv but aborts the render when the key is
+// missing or empty, instead of rendering a zero value.
+func (t *TemplateResource) require(key string) (string, error) {
+	v, err := t.store.GetValue(key)
+	if err != nil {
+		return "", fmt.Errorf("required key %s is missing", key)
+	}
+	if v == "" {
+		return "", fmt.Errorf("required key %s is empty", key)
+	}
+	return v, nil
+}
+
+// maskSecrets replaces every value marked sensitive by secretRef in s with a
+// redacted placeholder carrying a short digest, so two redacted values can
+// still be told apart.
+func (t *TemplateResource) maskSecrets(s string) string {
+	for v := range t.secrets {
+		if v == "" {
+			continue
+		}
+		sum := sha256.Sum256([]byte(v))
+		s = strings.Replace(s, v, fmt.Sprintf("<redacted sha256:%x>", sum[:4]), -1)
+	}
+	return s
+}
+
+// checkExternalModification compares the dest content hash against the hash
+// recorded after the last render. When they differ someone edited or removed
+// the file behind confd's back; a warning is logged and the cached render
+// fingerprint is dropped so the file is restored from the template.
+func (t *TemplateResource) checkExternalModification() {
+	renderState.Lock()
+	lastHash := renderState.destHashes[t.Dest]
+	renderState.Unlock()
+	if lastHash == "" {
+		return
+	}
+	if !util.IsFileExist(t.Dest) {
+		log.Warning(t.Dest + " was removed outside of confd since the last render")
+		renderState.Lock()
+		delete(renderState.fingerprints, t.Dest)
+		renderState.Unlock()
+		return
+	}
+	fi, err := util.FileStat(t.Dest)
+	if err != nil {
+		return
+	}
+	if fi.Md5 != lastHash {
+		log.Warning(t.Dest + " was modified outside of confd since the last render")
+		renderState.Lock()
+		delete(renderState.fingerprints, t.Dest)
+		renderState.Unlock()
+	}
+}
+
 // setFileMode sets the FileMode.
 func (t *TemplateResource) setFileMode() error {
 	if t.Mode == "" {
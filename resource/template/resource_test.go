@@ -82,7 +82,7 @@ func TestProcessTemplateResources(t *testing.T) {
 	}
 
 	os.Setenv("FOO", "bar")
-	storeClient, err := env.NewEnvClient()
+	storeClient, err := env.NewEnvClient("", "", "")
 	if err != nil {
 		t.Errorf(err.Error())
 	}
@@ -107,3 +107,94 @@ func TestProcessTemplateResources(t *testing.T) {
 		t.Errorf("Expected contents of dest == '%s', got %s", expected, string(results))
 	}
 }
+
+func TestDecodeResourceConfigFormats(t *testing.T) {
+	log.SetLevel("warn")
+	tests := []struct {
+		name     string
+		contents string
+	}{
+		{"foo.toml", "[template]\nsrc = \"foo.tmpl\"\ndest = \"/tmp/foo.conf\"\ncheck_cmd = \"true\"\nkeys = [\"foo\"]\n"},
+		{"foo.yaml", "template:\n  src: foo.tmpl\n  dest: /tmp/foo.conf\n  check_cmd: \"true\"\n  keys:\n    - foo\n"},
+		{"foo.json", "{\"template\": {\"src\": \"foo.tmpl\", \"dest\": \"/tmp/foo.conf\", \"check_cmd\": \"true\", \"keys\": [\"foo\"]}}"},
+	}
+	tempDir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	defer os.RemoveAll(tempDir)
+	for _, tt := range tests {
+		path := filepath.Join(tempDir, tt.name)
+		if err := ioutil.WriteFile(path, []byte(tt.contents), 0644); err != nil {
+			t.Fatal(err.Error())
+		}
+		var tc TemplateResourceConfig
+		if err := decodeResourceConfig(path, &tc); err != nil {
+			t.Errorf("Failed to decode %s: %s", tt.name, err.Error())
+			continue
+		}
+		tr := &tc.TemplateResource
+		if tr.Src != "foo.tmpl" || tr.Dest != "/tmp/foo.conf" || tr.CheckCmd != "true" {
+			t.Errorf("Unexpected resource from %s: %s -> %s", tt.name, tr.Src, tr.Dest)
+		}
+		if len(tr.Keys) != 1 || tr.Keys[0] != "foo" {
+			t.Errorf("Unexpected keys from %s: %v", tt.name, tr.Keys)
+		}
+	}
+}
+
+func TestProcessRestoresDeletedDest(t *testing.T) {
+	log.SetLevel("warn")
+	tempConfDir, err := createTempDirs()
+	if err != nil {
+		t.Fatalf("Failed to create temp dirs: %s", err.Error())
+	}
+	defer os.RemoveAll(tempConfDir)
+
+	srcTemplateFile := filepath.Join(tempConfDir, "templates", "foo.tmpl")
+	if err := ioutil.WriteFile(srcTemplateFile, []byte(`foo = {{getv "/foo"}}`), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	destFile, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create destFile: %s", err.Error())
+	}
+	defer os.Remove(destFile.Name())
+
+	resource := "[template]\nsrc = \"foo.tmpl\"\ndest = \"" + destFile.Name() + "\"\nkeys = [\"foo\"]\n"
+	if err := ioutil.WriteFile(filepath.Join(tempConfDir, "conf.d", "foo.toml"), []byte(resource), 0644); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	os.Setenv("FOO", "bar")
+	storeClient, err := env.NewEnvClient("", "", "")
+	if err != nil {
+		t.Fatal(err.Error())
+	}
+	c := Config{
+		ConfDir:     tempConfDir,
+		ConfigDir:   filepath.Join(tempConfDir, "conf.d"),
+		StoreClient: storeClient,
+		TemplateDir: filepath.Join(tempConfDir, "templates"),
+	}
+	if err := Process(c); err != nil {
+		t.Fatal(err.Error())
+	}
+
+	// Deleting the dest out-of-band must not be masked by the cached render
+	// fingerprint: the next cycle has to recreate the file.
+	if err := os.Remove(destFile.Name()); err != nil {
+		t.Fatal(err.Error())
+	}
+	if err := Process(c); err != nil {
+		t.Fatal(err.Error())
+	}
+	results, err := ioutil.ReadFile(destFile.Name())
+	if err != nil {
+		t.Fatalf("Dest was not recreated after out-of-band delete: %s", err.Error())
+	}
+	if expected := "foo = bar"; string(results) != expected {
+		t.Errorf("Expected contents of dest == '%s', got %s", expected, string(results))
+	}
+}
@@ -0,0 +1,211 @@
+package template
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// validateSchema parses the staged output as JSON (falling back to YAML) and
+// validates it against the resource's JSON Schema. Validation failures list
+// every offending path so the template can be fixed in one pass.
+func (t *TemplateResource) validateSchema() error {
+	data, err := ioutil.ReadFile(t.StageFile.Name())
+	if err != nil {
+		return err
+	}
+	var doc interface{}
+	if err := json.Unmarshal(data, &doc); err != nil {
+		var ydoc interface{}
+		if yerr := yaml.Unmarshal(data, &ydoc); yerr != nil {
+			return fmt.Errorf("rendered output for %s is neither valid JSON (%s) nor YAML (%s)", t.Dest, err, yerr)
+		}
+		doc = normalizeYAML(ydoc)
+	}
+
+	schemaBytes, err := ioutil.ReadFile(t.Schema)
+	if err != nil {
+		return err
+	}
+	var schema map[string]interface{}
+	if err := json.Unmarshal(schemaBytes, &schema); err != nil {
+		return fmt.Errorf("invalid schema %s: %s", t.Schema, err)
+	}
+
+	if errs := validateValue(doc, schema, "$"); len(errs) > 0 {
+		os.Remove(t.StageFile.Name())
+		return fmt.Errorf("schema validation failed for %s against %s:\n  %s",
+			t.Dest, t.Schema, strings.Join(errs, "\n  "))
+	}
+	return nil
+}
+
+// normalizeYAML converts the map[interface{}]interface{} values produced by
+// the YAML decoder into map[string]interface{} so both input formats
+// validate through the same code path.
+func normalizeYAML(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, val := range v {
+			m[fmt.Sprintf("%v", key)] = normalizeYAML(val)
+		}
+		return m
+	case []interface{}:
+		for i, val := range v {
+			v[i] = normalizeYAML(val)
+		}
+		return v
+	case int:
+		return float64(v)
+	default:
+		return v
+	}
+}
+
+// validateValue checks value against schema, returning one message per
+// violation with its JSON path. It supports the commonly used subset of JSON
+// Schema: type, enum, properties, required, additionalProperties (boolean),
+// items, pattern, minLength/maxLength, minimum/maximum, and
+// minItems/maxItems.
+func validateValue(value interface{}, schema map[string]interface{}, path string) []string {
+	var errs []string
+
+	if typ, ok := schema["type"].(string); ok {
+		if !matchesType(value, typ) {
+			return []string{fmt.Sprintf("%s: expected %s, got %s", path, typ, typeName(value))}
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if fmt.Sprintf("%v", candidate) == fmt.Sprintf("%v", value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs = append(errs, fmt.Sprintf("%s: value %v not in enum", path, value))
+		}
+	}
+
+	switch v := value.(type) {
+	case string:
+		if min, ok := schemaNumber(schema["minLength"]); ok && float64(len(v)) < min {
+			errs = append(errs, fmt.Sprintf("%s: string shorter than minLength %g", path, min))
+		}
+		if max, ok := schemaNumber(schema["maxLength"]); ok && float64(len(v)) > max {
+			errs = append(errs, fmt.Sprintf("%s: string longer than maxLength %g", path, max))
+		}
+		if pattern, ok := schema["pattern"].(string); ok {
+			if matched, err := regexp.MatchString(pattern, v); err == nil && !matched {
+				errs = append(errs, fmt.Sprintf("%s: %q does not match pattern %q", path, v, pattern))
+			}
+		}
+	case float64:
+		if min, ok := schemaNumber(schema["minimum"]); ok && v < min {
+			errs = append(errs, fmt.Sprintf("%s: %g below minimum %g", path, v, min))
+		}
+		if max, ok := schemaNumber(schema["maximum"]); ok && v > max {
+			errs = append(errs, fmt.Sprintf("%s: %g above maximum %g", path, v, max))
+		}
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, r := range required {
+				name, _ := r.(string)
+				if _, present := v[name]; !present {
+					errs = append(errs, fmt.Sprintf("%s: missing required property %q", path, name))
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		names := make([]string, 0, len(v))
+		for name := range v {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			propSchema, ok := properties[name].(map[string]interface{})
+			if !ok {
+				if extra, ok := schema["additionalProperties"].(bool); ok && !extra {
+					errs = append(errs, fmt.Sprintf("%s.%s: additional property not allowed", path, name))
+				}
+				continue
+			}
+			errs = append(errs, validateValue(v[name], propSchema, path+"."+name)...)
+		}
+	case []interface{}:
+		if min, ok := schemaNumber(schema["minItems"]); ok && float64(len(v)) < min {
+			errs = append(errs, fmt.Sprintf("%s: fewer than minItems %g", path, min))
+		}
+		if max, ok := schemaNumber(schema["maxItems"]); ok && float64(len(v)) > max {
+			errs = append(errs, fmt.Sprintf("%s: more than maxItems %g", path, max))
+		}
+		if itemSchema, ok := schema["items"].(map[string]interface{}); ok {
+			for i, item := range v {
+				errs = append(errs, validateValue(item, itemSchema, fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	}
+	return errs
+}
+
+// schemaNumber reads a numeric schema bound.
+func schemaNumber(value interface{}) (float64, bool) {
+	n, ok := value.(float64)
+	return n, ok
+}
+
+// matchesType reports whether value satisfies the JSON Schema type name.
+func matchesType(value interface{}, typ string) bool {
+	switch typ {
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		n, ok := value.(float64)
+		return ok && n == math.Trunc(n)
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "null":
+		return value == nil
+	}
+	return true
+}
+
+// typeName names a decoded JSON value for error messages.
+func typeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case float64:
+		return "number"
+	case bool:
+		return "boolean"
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", value)
+}
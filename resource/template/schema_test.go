@@ -0,0 +1,29 @@
+package template
+
+import "testing"
+
+func TestValidateValue(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"port"},
+		"properties": map[string]interface{}{
+			"port": map[string]interface{}{"type": "integer", "minimum": float64(1)},
+			"host": map[string]interface{}{"type": "string", "pattern": "^[a-z.]+$"},
+		},
+	}
+	doc := map[string]interface{}{"port": float64(0), "host": "WEB01"}
+	errs := validateValue(doc, schema, "$")
+	if len(errs) != 2 {
+		t.Fatalf("expected 2 violations, got %d: %v", len(errs), errs)
+	}
+	for _, e := range errs {
+		if e[0] != '$' {
+			t.Errorf("violation missing path prefix: %s", e)
+		}
+	}
+
+	doc = map[string]interface{}{"port": float64(8080), "host": "web.internal"}
+	if errs := validateValue(doc, schema, "$"); len(errs) != 0 {
+		t.Errorf("expected no violations, got %v", errs)
+	}
+}
@@ -1,17 +1,25 @@
 package template
 
 import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"math"
+	"math/rand"
 	"net"
 	"os"
 	"path"
+	"regexp"
 	"sort"
 	"strconv"
 	"strings"
 	"time"
+	"unicode"
 
 	util "github.com/abtreece/confd/util"
 	"github.com/kelseyhightower/memkv"
@@ -28,6 +36,10 @@ func newFuncMap() map[string]interface{} {
 	m["getenv"] = Getenv
 	m["join"] = strings.Join
 	m["datetime"] = time.Now
+	m["now"] = time.Now
+	m["dateFormat"] = DateFormat
+	m["dateAdd"] = DateAdd
+	m["unixToTime"] = UnixToTime
 	m["toUpper"] = strings.ToUpper
 	m["toLower"] = strings.ToLower
 	m["contains"] = strings.Contains
@@ -37,9 +49,14 @@ func newFuncMap() map[string]interface{} {
 	m["lookupIPV4"] = LookupIPV4
 	m["lookupIPV6"] = LookupIPV6
 	m["lookupSRV"] = LookupSRV
+	m["lookupTXT"] = LookupTXT
+	m["shuffleByWeight"] = ShuffleByWeight
 	m["fileExists"] = util.IsFileExist
 	m["base64Encode"] = Base64Encode
 	m["base64Decode"] = Base64Decode
+	m["sha1sum"] = SHA1Sum
+	m["sha256sum"] = SHA256Sum
+	m["hmacSHA256"] = HmacSHA256
 	m["parseBool"] = strconv.ParseBool
 	m["reverse"] = Reverse
 	m["sortByLength"] = SortByLength
@@ -52,9 +69,37 @@ func newFuncMap() map[string]interface{} {
 	m["seq"] = Seq
 	m["atoi"] = strconv.Atoi
 	m["hostname"] = GetHostname
+	m["fail"] = Fail
+	m["humanizeBytes"] = HumanizeBytes
+	m["humanizeNumber"] = HumanizeNumber
+	m["percent"] = Percent
+	m["camelCase"] = CamelCase
+	m["snakeCase"] = SnakeCase
+	m["kebabCase"] = KebabCase
+	m["title"] = Title
+	m["assertMatch"] = AssertMatch
 	return m
 }
 
+// Fail aborts the render with the given message. Useful for guarding
+// unsupported configuration combinations inside a template.
+func Fail(msg string) (string, error) {
+	return "", errors.New(msg)
+}
+
+// AssertMatch aborts the render unless value matches the regular expression
+// pattern. It returns the value unchanged on success.
+func AssertMatch(pattern, value string) (string, error) {
+	matched, err := regexp.MatchString(pattern, value)
+	if err != nil {
+		return "", err
+	}
+	if !matched {
+		return "", fmt.Errorf("value %q does not match %q", value, pattern)
+	}
+	return value, nil
+}
+
 func addFuncs(out, in map[string]interface{}) {
 	for name, fn := range in {
 		out[name] = fn
@@ -107,8 +152,8 @@ func SortByLength(values []string) []string {
 	return values
 }
 
-//Reverse returns the array in reversed order
-//works with []string and []KVPair
+// Reverse returns the array in reversed order
+// works with []string and []KVPair
 func Reverse(values interface{}) interface{} {
 	switch values.(type) {
 	case []string:
@@ -235,6 +280,121 @@ func LookupSRV(service, proto, name string) []*net.SRV {
 	return addrs
 }
 
+// LookupTXT returns the DNS TXT records for name sorted deterministically.
+func LookupTXT(name string) []string {
+	records, err := net.LookupTXT(name)
+	if err != nil {
+		return []string{}
+	}
+	sort.Strings(records)
+	return records
+}
+
+// ShuffleByWeight reorders SRV records using the RFC 2782 weighted selection
+// algorithm: records are grouped by ascending priority and randomly ordered
+// within each group proportionally to their weight.
+func ShuffleByWeight(addrs []*net.SRV) []*net.SRV {
+	byPriority := make(map[uint16][]*net.SRV)
+	priorities := []uint16{}
+	for _, addr := range addrs {
+		if _, ok := byPriority[addr.Priority]; !ok {
+			priorities = append(priorities, addr.Priority)
+		}
+		byPriority[addr.Priority] = append(byPriority[addr.Priority], addr)
+	}
+	sort.Slice(priorities, func(i, j int) bool { return priorities[i] < priorities[j] })
+	shuffled := make([]*net.SRV, 0, len(addrs))
+	for _, priority := range priorities {
+		group := append([]*net.SRV(nil), byPriority[priority]...)
+		for len(group) > 0 {
+			total := 0
+			for _, addr := range group {
+				total += int(addr.Weight)
+			}
+			pick := 0
+			if total > 0 {
+				pick = rand.Intn(total + 1)
+			}
+			running := 0
+			chosen := 0
+			for i, addr := range group {
+				running += int(addr.Weight)
+				if running >= pick {
+					chosen = i
+					break
+				}
+			}
+			shuffled = append(shuffled, group[chosen])
+			group = append(group[:chosen], group[chosen+1:]...)
+		}
+	}
+	return shuffled
+}
+
+// UnixToTime converts an epoch value stored in the backend - seconds as a
+// string, int, or int64 - into a UTC time.
+func UnixToTime(v interface{}) (time.Time, error) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, nil
+	case int:
+		return time.Unix(int64(t), 0).UTC(), nil
+	case int64:
+		return time.Unix(t, 0).UTC(), nil
+	case string:
+		secs, err := strconv.ParseInt(t, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid epoch value %q: %s", t, err)
+		}
+		return time.Unix(secs, 0).UTC(), nil
+	default:
+		return time.Time{}, fmt.Errorf("cannot convert %T to time", v)
+	}
+}
+
+// DateFormat formats a time - or anything UnixToTime accepts - with the
+// given reference layout. The value comes last so it can be piped.
+func DateFormat(layout string, v interface{}) (string, error) {
+	t, err := UnixToTime(v)
+	if err != nil {
+		return "", err
+	}
+	return t.Format(layout), nil
+}
+
+// DateAdd shifts a time by a duration string such as "1h30m" or "-24h". The
+// value comes last so it can be piped.
+func DateAdd(duration string, v interface{}) (time.Time, error) {
+	t, err := UnixToTime(v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	d, err := time.ParseDuration(duration)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return t.Add(d), nil
+}
+
+// SHA1Sum returns the hex encoded SHA-1 digest of data.
+func SHA1Sum(data string) string {
+	sum := sha1.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// SHA256Sum returns the hex encoded SHA-256 digest of data.
+func SHA256Sum(data string) string {
+	sum := sha256.Sum256([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+// HmacSHA256 returns the hex encoded HMAC-SHA256 of data under key.
+func HmacSHA256(key, data string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
 func Base64Encode(data string) string {
 	return base64.StdEncoding.EncodeToString([]byte(data))
 }
@@ -243,3 +403,182 @@ func Base64Decode(data string) (string, error) {
 	s, err := base64.StdEncoding.DecodeString(data)
 	return string(s), err
 }
+
+// toFloat converts a template numeric input - backend values arrive as
+// strings - to a float64.
+func toFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), nil
+	case int64:
+		return float64(n), nil
+	case float64:
+		return n, nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid numeric value %q: %s", n, err)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("cannot convert %T to a number", v)
+	}
+}
+
+// byteUnits are the binary unit suffixes accepted and emitted by
+// HumanizeBytes, in ascending order.
+var byteUnits = []string{"B", "KB", "MB", "GB", "TB", "PB", "EB"}
+
+// HumanizeBytes renders a byte count with a binary unit suffix: 536870912
+// becomes "512MB". An explicit unit ("KB", "MB", ...) forces that unit for
+// configs that only accept one. Non-integral values keep one decimal.
+func HumanizeBytes(v interface{}, unit ...string) (string, error) {
+	bytes, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	if len(unit) > 0 {
+		want := strings.ToUpper(unit[0])
+		for _, u := range byteUnits {
+			if u == want {
+				return formatScaled(bytes) + u, nil
+			}
+			bytes /= 1024
+		}
+		return "", fmt.Errorf("unknown byte unit %q", unit[0])
+	}
+	i := 0
+	for bytes >= 1024 && i < len(byteUnits)-1 {
+		bytes /= 1024
+		i++
+	}
+	return formatScaled(bytes) + byteUnits[i], nil
+}
+
+// formatScaled renders a scaled quantity without a trailing ".0" so whole
+// values stay in the integer form most configs require.
+func formatScaled(v float64) string {
+	if v == math.Trunc(v) {
+		return strconv.FormatFloat(v, 'f', 0, 64)
+	}
+	return strconv.FormatFloat(v, 'f', 1, 64)
+}
+
+// HumanizeNumber groups the integer part of a number with thousands
+// separators: 1234567 becomes "1,234,567".
+func HumanizeNumber(v interface{}) (string, error) {
+	f, err := toFloat(v)
+	if err != nil {
+		return "", err
+	}
+	s := strconv.FormatFloat(f, 'f', -1, 64)
+	sign := ""
+	if strings.HasPrefix(s, "-") {
+		sign, s = "-", s[1:]
+	}
+	intPart, frac := s, ""
+	if dot := strings.Index(s, "."); dot >= 0 {
+		intPart, frac = s[:dot], s[dot:]
+	}
+	var grouped []string
+	for len(intPart) > 3 {
+		grouped = append([]string{intPart[len(intPart)-3:]}, grouped...)
+		intPart = intPart[:len(intPart)-3]
+	}
+	grouped = append([]string{intPart}, grouped...)
+	return sign + strings.Join(grouped, ",") + frac, nil
+}
+
+// Percent renders part of whole as a percentage: {{percent 3 4}} becomes
+// "75%". An optional precision sets the number of decimal places.
+func Percent(part, whole interface{}, precision ...int) (string, error) {
+	p, err := toFloat(part)
+	if err != nil {
+		return "", err
+	}
+	w, err := toFloat(whole)
+	if err != nil {
+		return "", err
+	}
+	if w == 0 {
+		return "", errors.New("cannot take a percentage of zero")
+	}
+	decimals := 0
+	if len(precision) > 0 {
+		decimals = precision[0]
+	}
+	return strconv.FormatFloat(p/w*100, 'f', decimals, 64) + "%", nil
+}
+
+// splitWords breaks an identifier into lowercase words, splitting on
+// underscores, hyphens, dots, and spaces as well as on case boundaries.
+// Acronym runs stay together, so "HTTPServerPort" yields http, server, port.
+func splitWords(s string) []string {
+	words := []string{}
+	word := []rune{}
+	flush := func() {
+		if len(word) > 0 {
+			words = append(words, strings.ToLower(string(word)))
+			word = word[:0]
+		}
+	}
+	runes := []rune(s)
+	for i, r := range runes {
+		switch {
+		case r == '_' || r == '-' || r == '.' || r == ' ':
+			flush()
+		case unicode.IsUpper(r):
+			if i > 0 && !unicode.IsUpper(runes[i-1]) {
+				flush()
+			} else if i > 0 && i+1 < len(runes) && unicode.IsLower(runes[i+1]) {
+				flush()
+			}
+			word = append(word, r)
+		default:
+			word = append(word, r)
+		}
+	}
+	flush()
+	return words
+}
+
+// capitalize uppercases the first rune of a word.
+func capitalize(w string) string {
+	r := []rune(w)
+	if len(r) > 0 {
+		r[0] = unicode.ToUpper(r[0])
+	}
+	return string(r)
+}
+
+// CamelCase converts an identifier to camelCase: {{camelCase "max_open_conns"}}
+// becomes "maxOpenConns".
+func CamelCase(s string) string {
+	words := splitWords(s)
+	for i := 1; i < len(words); i++ {
+		words[i] = capitalize(words[i])
+	}
+	return strings.Join(words, "")
+}
+
+// SnakeCase converts an identifier to snake_case: {{snakeCase "maxOpenConns"}}
+// becomes "max_open_conns".
+func SnakeCase(s string) string {
+	return strings.Join(splitWords(s), "_")
+}
+
+// KebabCase converts an identifier to kebab-case: {{kebabCase "MaxOpenConns"}}
+// becomes "max-open-conns".
+func KebabCase(s string) string {
+	return strings.Join(splitWords(s), "-")
+}
+
+// Title converts an identifier to space-separated capitalized words:
+// {{title "max_open_conns"}} becomes "Max Open Conns".
+func Title(s string) string {
+	words := splitWords(s)
+	for i := range words {
+		words[i] = capitalize(words[i])
+	}
+	return strings.Join(words, " ")
+}
@@ -3,6 +3,7 @@ package template
 import (
 	"fmt"
 	"io/ioutil"
+	"net"
 	"os"
 	"testing"
 
@@ -542,6 +543,25 @@ keys = [
 			tr.store.Set("/test/count", "3")
 		},
 	},
+
+	templateTest{
+		desc: "require and assertMatch test",
+		toml: `
+[template]
+src = "test.conf.tmpl"
+dest = "./tmp/test.conf"
+keys = [
+    "/test/port",
+]
+`,
+		tmpl: `port: {{assertMatch "^[0-9]+$" (require "/test/port")}}
+`,
+		expected: `port: 8080
+`,
+		updateStore: func(tr *TemplateResource) {
+			tr.store.Set("/test/port", "8080")
+		},
+	},
 }
 
 // TestTemplates runs all tests in templateTests
@@ -633,3 +653,102 @@ func templateResource() (*TemplateResource, error) {
 	tr.FileMode = 0666
 	return tr, nil
 }
+
+func TestDateFuncs(t *testing.T) {
+	got, err := DateFormat("2006-01-02 15:04:05", "1609459200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != "2021-01-01 00:00:00" {
+		t.Errorf("DateFormat(1609459200) = %s", got)
+	}
+	shifted, err := DateAdd("24h", "1609459200")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shifted.Format("2006-01-02") != "2021-01-02" {
+		t.Errorf("DateAdd(24h) = %s", shifted)
+	}
+	if _, err := UnixToTime("not-a-number"); err == nil {
+		t.Error("UnixToTime accepted a non-numeric value")
+	}
+}
+
+func TestDigestFuncs(t *testing.T) {
+	if got := SHA1Sum("abc"); got != "a9993e364706816aba3e25717850c26c9cd0d89d" {
+		t.Errorf("SHA1Sum(abc) = %s", got)
+	}
+	if got := SHA256Sum("abc"); got != "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad" {
+		t.Errorf("SHA256Sum(abc) = %s", got)
+	}
+	if got := HmacSHA256("key", "abc"); got != HmacSHA256("key", "abc") || len(got) != 64 {
+		t.Errorf("HmacSHA256(key, abc) = %s", got)
+	}
+}
+
+func TestShuffleByWeight(t *testing.T) {
+	addrs := []*net.SRV{
+		{Target: "c.example.com.", Port: 80, Priority: 20, Weight: 10},
+		{Target: "a.example.com.", Port: 80, Priority: 10, Weight: 60},
+		{Target: "b.example.com.", Port: 80, Priority: 10, Weight: 40},
+	}
+	shuffled := ShuffleByWeight(addrs)
+	if len(shuffled) != len(addrs) {
+		t.Fatalf("expected %d records, got %d", len(addrs), len(shuffled))
+	}
+	// Priority ordering is strict regardless of weight shuffling.
+	if shuffled[2].Target != "c.example.com." {
+		t.Errorf("expected lowest priority record last, got %s", shuffled[2].Target)
+	}
+}
+
+func TestHumanizeFuncs(t *testing.T) {
+	if got, _ := HumanizeBytes(536870912); got != "512MB" {
+		t.Errorf("HumanizeBytes(536870912) = %s", got)
+	}
+	if got, _ := HumanizeBytes("536870912", "KB"); got != "524288KB" {
+		t.Errorf("HumanizeBytes(536870912, KB) = %s", got)
+	}
+	if got, _ := HumanizeBytes(1536); got != "1.5KB" {
+		t.Errorf("HumanizeBytes(1536) = %s", got)
+	}
+	if _, err := HumanizeBytes(1024, "XB"); err == nil {
+		t.Error("HumanizeBytes accepted an unknown unit")
+	}
+	if got, _ := HumanizeNumber(1234567); got != "1,234,567" {
+		t.Errorf("HumanizeNumber(1234567) = %s", got)
+	}
+	if got, _ := HumanizeNumber("-1234.5"); got != "-1,234.5" {
+		t.Errorf("HumanizeNumber(-1234.5) = %s", got)
+	}
+	if got, _ := Percent(3, 4); got != "75%" {
+		t.Errorf("Percent(3, 4) = %s", got)
+	}
+	if got, _ := Percent("1", "3", 1); got != "33.3%" {
+		t.Errorf("Percent(1, 3, 1) = %s", got)
+	}
+	if _, err := Percent(1, 0); err == nil {
+		t.Error("Percent accepted a zero whole")
+	}
+}
+
+func TestCasingFuncs(t *testing.T) {
+	if got := CamelCase("max_open_conns"); got != "maxOpenConns" {
+		t.Errorf("CamelCase(max_open_conns) = %s", got)
+	}
+	if got := CamelCase("HTTP-server.port"); got != "httpServerPort" {
+		t.Errorf("CamelCase(HTTP-server.port) = %s", got)
+	}
+	if got := SnakeCase("maxOpenConns"); got != "max_open_conns" {
+		t.Errorf("SnakeCase(maxOpenConns) = %s", got)
+	}
+	if got := SnakeCase("HTTPServerPort"); got != "http_server_port" {
+		t.Errorf("SnakeCase(HTTPServerPort) = %s", got)
+	}
+	if got := KebabCase("MaxOpenConns"); got != "max-open-conns" {
+		t.Errorf("KebabCase(MaxOpenConns) = %s", got)
+	}
+	if got := Title("max_open_conns"); got != "Max Open Conns" {
+		t.Errorf("Title(max_open_conns) = %s", got)
+	}
+}
@@ -0,0 +1,7 @@
+package util
+
+// LockFile is a no-op on Windows where flock style advisory locking is not
+// available. It returns a function that releases the lock.
+func LockFile(path string) (func(), error) {
+	return func() {}, nil
+}
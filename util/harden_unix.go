@@ -0,0 +1,20 @@
+//go:build !windows
+// +build !windows
+
+package util
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// SetChroot makes c run inside a chroot at dir. The kernel applies the
+// chroot in the child before the command is executed, so the binary and
+// everything it needs must exist under dir.
+func SetChroot(c *exec.Cmd, dir string) error {
+	if c.SysProcAttr == nil {
+		c.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	c.SysProcAttr.Chroot = dir
+	return nil
+}
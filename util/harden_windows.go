@@ -0,0 +1,14 @@
+//go:build windows
+// +build windows
+
+package util
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// SetChroot is not supported on Windows.
+func SetChroot(c *exec.Cmd, dir string) error {
+	return errors.New("chroot is not supported on windows")
+}
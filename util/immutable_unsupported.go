@@ -0,0 +1,18 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import "errors"
+
+// IsImmutable reports whether path carries the immutable attribute. Only
+// Linux supports file attributes; other platforms report false.
+func IsImmutable(path string) (bool, error) {
+	return false, nil
+}
+
+// SetImmutable sets or clears the immutable attribute on path. It is not
+// supported off Linux.
+func SetImmutable(path string, immutable bool) error {
+	return errors.New("immutable attribute is only supported on linux")
+}
@@ -0,0 +1,16 @@
+//go:build linux
+// +build linux
+
+package util
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// NoNewPrivs marks the process as unable to gain privileges through setuid
+// binaries or file capabilities. The flag is irreversible and inherited by
+// every child started afterwards, which is how it reaches the check and
+// reload commands.
+func NoNewPrivs() error {
+	return unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+}
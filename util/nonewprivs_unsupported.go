@@ -0,0 +1,11 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import "errors"
+
+// NoNewPrivs is only supported on Linux.
+func NoNewPrivs() error {
+	return errors.New("no_new_privs is only supported on linux")
+}
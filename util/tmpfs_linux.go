@@ -0,0 +1,24 @@
+//go:build linux
+// +build linux
+
+package util
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// IsTmpfs reports whether path lives on a memory-backed filesystem (tmpfs or
+// ramfs), so sensitive content staged there never reaches a disk.
+func IsTmpfs(path string) (bool, error) {
+	var fs unix.Statfs_t
+	if err := unix.Statfs(path, &fs); err != nil {
+		return false, err
+	}
+	return fs.Type == unix.TMPFS_MAGIC || fs.Type == unix.RAMFS_MAGIC, nil
+}
+
+// LockMemory locks current and future pages of the process into RAM so
+// rendered secrets cannot be written to swap.
+func LockMemory() error {
+	return unix.Mlockall(unix.MCL_CURRENT | unix.MCL_FUTURE)
+}
@@ -0,0 +1,17 @@
+//go:build !linux
+// +build !linux
+
+package util
+
+import "errors"
+
+// IsTmpfs reports whether path lives on a memory-backed filesystem. The
+// check is only implemented on Linux; other platforms report false.
+func IsTmpfs(path string) (bool, error) {
+	return false, errors.New("tmpfs detection is only supported on linux")
+}
+
+// LockMemory locks process pages into RAM. It is not supported off Linux.
+func LockMemory() error {
+	return errors.New("memory locking is only supported on linux")
+}
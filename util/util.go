@@ -153,3 +153,28 @@ func recursiveLookup(root string, pattern string, dirsLookup bool) ([]string, er
 	}
 	return result, nil
 }
+
+// Fsync flushes a file's contents to stable storage.
+func Fsync(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return f.Sync()
+}
+
+// FsyncDir flushes a directory entry to stable storage, making a rename in
+// that directory durable across power loss. Filesystems that do not
+// support syncing directories report success.
+func FsyncDir(dir string) error {
+	f, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil && !os.IsPermission(err) {
+		return err
+	}
+	return nil
+}